@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// BatchOperation - один шаг POST /_batch. Id и значения в Body могут ссылаться на результат
+// предыдущего шага: {"$ref": "0.id"} - поле id результата нулевой операции
+type BatchOperation struct {
+	Op    string         `json:"op"` // create, update или delete
+	Table string         `json:"table"`
+	Id    Any            `json:"id"`
+	Body  map[string]Any `json:"body"`
+}
+
+type BatchRequest struct {
+	Operations []BatchOperation `json:"operations"`
+}
+
+// resolveRef возвращает значение по ссылке вида "0.id" на уже выполненный шаг
+func resolveRef(ref string, results []map[string]Any) (Any, error) {
+	parts := strings.SplitN(ref, ".", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("bad reference: %s", ref)
+	}
+
+	i, e := strconv.Atoi(parts[0])
+	if e != nil || i < 0 || i >= len(results) {
+		return nil, fmt.Errorf("bad reference: %s", ref)
+	}
+
+	v, ok := results[i][parts[1]]
+	if !ok {
+		return nil, fmt.Errorf("bad reference: %s", ref)
+	}
+
+	return v, nil
+}
+
+// resolveRefs заменяет все {"$ref": "N.field"} в значении на результаты предыдущих шагов
+func resolveRefs(v Any, results []map[string]Any) (Any, error) {
+	switch t := v.(type) {
+	case map[string]Any:
+		if ref, ok := t["$ref"].(string); ok && len(t) == 1 {
+			return resolveRef(ref, results)
+		}
+
+		resolved := make(map[string]Any, len(t))
+		for k, item := range t {
+			r, e := resolveRefs(item, results)
+			if e != nil {
+				return nil, e
+			}
+			resolved[k] = r
+		}
+
+		return resolved, nil
+	case []Any:
+		resolved := make([]Any, len(t))
+		for i, item := range t {
+			r, e := resolveRefs(item, results)
+			if e != nil {
+				return nil, e
+			}
+			resolved[i] = r
+		}
+
+		return resolved, nil
+	default:
+		return v, nil
+	}
+}
+
+// recordFilters разбирает id шага в условия по primary key тем же разбором, что и /$table/$id
+func (explorer *DbExplorer) recordFilters(tableName string, id Any) ([]Filter, error) {
+	return ParseIdValue(id, explorer.findPKs(tableName))
+}
+
+// runOperation выполняет один шаг пакета в транзакции и возвращает его результат.
+// Ошибки разбора и проверки - ошибки клиента, ошибки базы (ограничения, взаимоблокировки, драйвер) - 500
+func (explorer *DbExplorer) runOperation(q queryer, op BatchOperation, results []map[string]Any) (map[string]Any, int, error) {
+	if te := explorer.tableShouldExist(op.Table); te != nil {
+		return nil, http.StatusNotFound, te
+	}
+
+	id, re := resolveRefs(op.Id, results)
+	if re != nil {
+		return nil, http.StatusBadRequest, re
+	}
+
+	resolved, re := resolveRefs(map[string]Any(op.Body), results)
+	if re != nil {
+		return nil, http.StatusBadRequest, re
+	}
+	body, _ := resolved.(map[string]Any)
+	if body == nil {
+		body = map[string]Any{}
+	}
+
+	switch op.Op {
+	case "create":
+		if violations := explorer.validateRecord(op.Table, body, validateCreate, nil); len(violations) > 0 {
+			return nil, http.StatusBadRequest, Violations(violations)
+		}
+
+		kv, pe := explorer.insertValues(op.Table, body, nil)
+		if pe != nil {
+			return nil, http.StatusBadRequest, pe
+		}
+
+		ids, ie := explorer.insertMany(q, op.Table, []map[string]Any{kv}, nil)
+		if ie != nil {
+			return nil, http.StatusInternalServerError, ie
+		}
+
+		return ids[0], 0, nil
+	case "update":
+		filters, fe := explorer.recordFilters(op.Table, id)
+		if fe != nil {
+			return nil, http.StatusBadRequest, fe
+		}
+
+		if violations := explorer.validateRecord(op.Table, body, validateUpdate, nil); len(violations) > 0 {
+			return nil, http.StatusBadRequest, Violations(violations)
+		}
+
+		kv, pe := explorer.updateValues(op.Table, body)
+		if pe != nil {
+			return nil, http.StatusBadRequest, pe
+		}
+
+		updated, ue := explorer.updateRows(q, op.Table, kv, filters)
+		if ue != nil {
+			return nil, http.StatusInternalServerError, ue
+		}
+
+		return map[string]Any{"updated": updated}, 0, nil
+	case "delete":
+		filters, fe := explorer.recordFilters(op.Table, id)
+		if fe != nil {
+			return nil, http.StatusBadRequest, fe
+		}
+
+		deleted, de := explorer.deleteRows(q, op.Table, filters)
+		if de != nil {
+			return nil, http.StatusInternalServerError, de
+		}
+
+		return map[string]Any{"deleted": deleted}, 0, nil
+	default:
+		return nil, http.StatusBadRequest, fmt.Errorf("unknown op: %s", op.Op)
+	}
+}
+
+//POST /_batch - выполняет операции по порядку в одной транзакции, при ошибке любого шага откатываются все.
+//{"operations": [{"op": "create", "table": "users", "body": {...}}, {"op": "update", "table": "items", "id": {"$ref": "0.user_id"}, "body": {...}}]}
+func (explorer *DbExplorer) handleBatch(w http.ResponseWriter, r *http.Request) {
+	body, re := ioutil.ReadAll(r.Body)
+	panicOnError(re)
+
+	batch := BatchRequest{}
+	if de := decodeJsonBody(body, &batch); de != nil {
+		handleServerError(w, http.StatusBadRequest, fmt.Errorf("bad json"))
+
+		return
+	}
+
+	tx, be := explorer.db.Begin()
+	panicOnError(be)
+	// после Commit откат ничего не делает, а при панике в шаге транзакция не останется висеть
+	defer tx.Rollback()
+
+	results := make([]map[string]Any, 0, len(batch.Operations))
+	for i, op := range batch.Operations {
+		result, status, oe := explorer.runOperation(tx, op, results)
+
+		if oe != nil {
+			panicOnError(tx.Rollback())
+
+			response := map[string]interface{}{"index": i}
+			if violations, ok := oe.(Violations); ok {
+				response["errors"] = violations
+			}
+
+			w.WriteHeader(status)
+			w.Write(ServerError{
+				Error:    fmt.Sprintf("operation %d: %v", i, oe),
+				Response: response,
+			}.Marshal())
+
+			return
+		}
+
+		results = append(results, result)
+	}
+
+	panicOnError(tx.Commit())
+	handleServerResponse(w, map[string]interface{}{
+		"results": results,
+	})
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	bulkChunkRows = 100
+	// старые сборки sqlite не принимают больше 999 плейсхолдеров в одном запросе
+	bulkMaxArgs = 999
+)
+
+// RowError - ошибка в одной записи пакетной вставки, Index - номер записи во входных данных,
+// Pointer - поле внутри записи, если ошибку нашла проверка по JSON Schema
+type RowError struct {
+	Index   int    `json:"index"`
+	Pointer string `json:"pointer,omitempty"`
+	Error   string `json:"error"`
+}
+
+func isNDJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Content-Type"), "ndjson")
+}
+
+// isBulkBody: в PUT пришёл массив записей или поток NDJSON, а не одна запись
+func isBulkBody(r *http.Request, body []byte) bool {
+	trimmed := bytes.TrimSpace(body)
+
+	return isNDJSON(r) || (len(trimmed) > 0 && trimmed[0] == '[')
+}
+
+// decodeBulkBody разбирает массив или NDJSON в записи. Элементы, не являющиеся объектами, попадают в ошибки по номеру,
+// а сломанный json целиком - в ошибку запроса
+func decodeBulkBody(body []byte, ndjson bool) ([]map[string]Any, []RowError, error) {
+	var raws []json.RawMessage
+
+	if ndjson {
+		decoder := json.NewDecoder(bytes.NewReader(body))
+
+		for {
+			var raw json.RawMessage
+			e := decoder.Decode(&raw)
+
+			if e == io.EOF {
+				break
+			}
+
+			if e != nil {
+				return nil, nil, fmt.Errorf("bad json in record %d", len(raws))
+			}
+
+			raws = append(raws, raw)
+		}
+	} else if e := json.Unmarshal(body, &raws); e != nil {
+		return nil, nil, fmt.Errorf("bad json")
+	}
+
+	records := make([]map[string]Any, len(raws))
+	var errs []RowError
+	for i, raw := range raws {
+		if e := decodeJsonBody(raw, &records[i]); e != nil || records[i] == nil {
+			errs = append(errs, RowError{Index: i, Error: "record must be an object"})
+		}
+	}
+
+	return records, errs, nil
+}
+
+func sortedKeys(kv map[string]Any) []string {
+	ks := keys(kv)
+	sort.Strings(ks)
+
+	return ks
+}
+
+func sameColumns(kv map[string]Any, columns []string) bool {
+	if len(kv) != len(columns) {
+		return false
+	}
+
+	for _, c := range columns {
+		if _, ok := kv[c]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func rowTuples(columns []string, rows []map[string]Any) [][]Any {
+	tuples := make([][]Any, len(rows))
+	for i, kv := range rows {
+		tuples[i] = mapAny(columns, func(k string) Any { return kv[k] })
+	}
+
+	return tuples
+}
+
+// insertMany вставляет записи многострочными insert-ами: подряд идущие записи с одинаковым набором колонок
+// объединяются в один запрос, пропущенные колонки получают значения по умолчанию. Возвращает ключи записей по порядку.
+// С непустым target конфликтующие записи обновляются (upsert)
+func (explorer *DbExplorer) insertMany(q queryer, tableName string, rows []map[string]Any, target []string) ([]map[string]Any, error) {
+	autoPk := explorer.autoIncrementPk(tableName)
+	ids := make([]map[string]Any, 0, len(rows))
+
+	for start := 0; start < len(rows); {
+		columns := sortedKeys(rows[start])
+
+		limit := bulkChunkRows
+		if len(columns) > 0 && bulkMaxArgs/len(columns) < limit {
+			limit = bulkMaxArgs / len(columns)
+		}
+
+		end := start + 1
+		for end < len(rows) && end-start < limit && sameColumns(rows[end], columns) {
+			end++
+		}
+		chunk := rows[start:end]
+		start = end
+
+		if len(columns) == 0 {
+			// многострочного DEFAULT VALUES нет, такие записи вставляем по одной
+			for _, kv := range chunk {
+				insert, args, be := explorer.builder.Insert(tableName, nil, nil)
+				if be != nil {
+					return nil, be
+				}
+
+				if len(autoPk) > 0 {
+					id, ie := explorer.dialect.InsertReturningId(q, insert, autoPk, args...)
+					if ie != nil {
+						return nil, ie
+					}
+					ids = append(ids, map[string]Any{autoPk: id})
+				} else {
+					if _, ee := q.Exec(insert, args...); ee != nil {
+						return nil, ee
+					}
+					ids = append(ids, explorer.pkValues(tableName, kv))
+				}
+			}
+
+			continue
+		}
+
+		if len(target) > 0 {
+			unique, positions := dedupByKey(chunk, target)
+
+			upsert, args, be := explorer.builder.Upsert(tableName, columns, rowTuples(columns, unique), target)
+			if be != nil {
+				return nil, be
+			}
+
+			if _, ee := q.Exec(upsert, args...); ee != nil {
+				return nil, ee
+			}
+
+			keys, le := explorer.lookupKeys(q, tableName, unique, target)
+			if le != nil {
+				return nil, le
+			}
+
+			for _, p := range positions {
+				ids = append(ids, keys[p])
+			}
+
+			continue
+		}
+
+		insert, args, be := explorer.builder.InsertMany(tableName, columns, rowTuples(columns, chunk))
+		if be != nil {
+			return nil, be
+		}
+
+		if len(autoPk) > 0 {
+			inserted, ie := explorer.dialect.InsertReturningIds(q, insert, autoPk, len(chunk), args...)
+			if ie != nil {
+				return nil, ie
+			}
+
+			for _, id := range inserted {
+				ids = append(ids, map[string]Any{autoPk: id})
+			}
+		} else {
+			if _, ee := q.Exec(insert, args...); ee != nil {
+				return nil, ee
+			}
+
+			for _, kv := range chunk {
+				ids = append(ids, explorer.pkValues(tableName, kv))
+			}
+		}
+	}
+
+	return ids, nil
+}
+
+//PUT /$table с массивом записей или NDJSON (Content-Type: application/x-ndjson) - пакетная вставка в одной транзакции.
+//Все записи проверяются до вставки; при ошибках ничего не вставляется, а в ответе номера записей и причины
+func (explorer *DbExplorer) handleBulkInsert(w http.ResponseWriter, r *http.Request, rp *RequestParams, parent *Filter, target []string, body []byte) {
+	records, errs, de := decodeBulkBody(body, isNDJSON(r))
+	if de != nil {
+		handleServerError(w, http.StatusBadRequest, de)
+
+		return
+	}
+
+	rows := make([]map[string]Any, len(records))
+	for i, data := range records {
+		if data == nil {
+			continue
+		}
+
+		if violations := explorer.validateRecord(rp.Table, data, validateCreate, parent); len(violations) > 0 {
+			for _, v := range violations {
+				errs = append(errs, RowError{Index: i, Pointer: v.Pointer, Error: v.Error})
+			}
+
+			continue
+		}
+
+		kv, pe := explorer.upsertValues(rp.Table, data, parent, target)
+		if pe != nil {
+			errs = append(errs, RowError{Index: i, Error: pe.Error()})
+
+			continue
+		}
+
+		rows[i] = kv
+	}
+
+	if len(errs) > 0 {
+		sort.SliceStable(errs, func(i, j int) bool { return errs[i].Index < errs[j].Index })
+
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(ServerError{
+			Error:    "invalid records",
+			Response: map[string]interface{}{"errors": errs},
+		}.Marshal())
+
+		return
+	}
+
+	tx, be := explorer.db.Begin()
+	panicOnError(be)
+	defer tx.Rollback()
+
+	ids, ie := explorer.insertMany(tx, rp.Table, rows, target)
+	panicOnError(ie)
+
+	response := map[string]interface{}{
+		"inserted": len(ids),
+		"ids":      ids,
+	}
+
+	if wantsRepresentation(r) {
+		records := make([]Any, len(ids))
+		for i, key := range ids {
+			records[i], ie = explorer.readRecord(tx, rp.Table, explorer.keyFilters(rp.Table, key))
+			panicOnError(ie)
+		}
+		response["records"] = records
+		preferenceApplied(w)
+	}
+	panicOnError(tx.Commit())
+
+	handleServerResponse(w, response)
+}
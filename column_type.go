@@ -0,0 +1,738 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type TypeKind int
+
+const (
+	KindString TypeKind = iota
+	KindInt
+	KindBool
+	KindDecimal
+	KindFloat
+	KindDate
+	KindDateTime
+	KindTime
+	KindYear
+	KindJSON
+	KindBinary
+	KindEnum
+	KindSet
+	KindBit
+)
+
+// ColumnType - разобранный тип колонки в том виде, в каком его отдаёт SHOW FULL COLUMNS:
+// int(11) unsigned, decimal(10,2), varchar(64), enum('a','b') и т.д.
+type ColumnType struct {
+	Kind      TypeKind
+	Base      string
+	Unsigned  bool
+	Length    int // varchar(n), char(n), binary(n), bit(n)
+	Precision int // decimal(p,s)
+	Scale     int
+	Values    []string // члены enum и set
+	MaxBytes  int      // лимит в байтах для text и blob без явной длины, см. lengthLimits
+}
+
+var columnTypeRe = regexp.MustCompile(`^([a-z][a-z0-9 ]*?)\s*(?:\((.*)\))?((?:\s+(?:unsigned|signed|zerofill))*)$`)
+
+var intBits = map[string]uint{
+	"tinyint":   8,
+	"smallint":  16,
+	"mediumint": 24,
+	"int":       32,
+	"integer":   32,
+	"bigint":    64,
+}
+
+// лимиты в байтах для типов без явной длины. Это лимиты mysql: в postgres и sqlite text и bytea не ограничены
+var lengthLimits = map[string]int{
+	"tinytext":   255,
+	"text":       65535,
+	"mediumtext": 16777215,
+	"longtext":   4294967295,
+	"tinyblob":   255,
+	"blob":       65535,
+	"mediumblob": 16777215,
+	"longblob":   4294967295,
+}
+
+var kindsByBase = map[string]TypeKind{
+	"tinyint":    KindInt,
+	"smallint":   KindInt,
+	"mediumint":  KindInt,
+	"int":        KindInt,
+	"integer":    KindInt,
+	"bigint":     KindInt,
+	"bool":       KindBool,
+	"boolean":    KindBool,
+	"decimal":    KindDecimal,
+	"dec":        KindDecimal,
+	"numeric":    KindDecimal,
+	"fixed":      KindDecimal,
+	"float":      KindFloat,
+	"double":     KindFloat,
+	"real":       KindFloat,
+	"date":       KindDate,
+	"datetime":   KindDateTime,
+	"timestamp":  KindDateTime,
+	"time":       KindTime,
+	"year":       KindYear,
+	"json":       KindJSON,
+	"binary":     KindBinary,
+	"varbinary":  KindBinary,
+	"tinyblob":   KindBinary,
+	"blob":       KindBinary,
+	"mediumblob": KindBinary,
+	"longblob":   KindBinary,
+	"enum":       KindEnum,
+	"set":        KindSet,
+	"bit":        KindBit,
+}
+
+// ParseColumnType разбирает полное имя типа. Незнакомые типы (uuid, inet и т.п.) считаются строковыми
+func ParseColumnType(t string) (ColumnType, error) {
+	t = strings.ToLower(strings.TrimSpace(t))
+	m := columnTypeRe.FindStringSubmatch(t)
+
+	if m == nil {
+		return ColumnType{}, fmt.Errorf("cannot parse type: %s", t)
+	}
+
+	ct := ColumnType{
+		Kind:     KindString,
+		Base:     m[1],
+		Unsigned: strings.Contains(m[3], "unsigned"),
+	}
+
+	if k, ok := kindsByBase[ct.Base]; ok {
+		ct.Kind = k
+	}
+
+	args := m[2]
+
+	switch ct.Kind {
+	case KindEnum, KindSet:
+		values, e := parseEnumValues(args)
+
+		if e != nil {
+			return ColumnType{}, fmt.Errorf("cannot parse type: %s", t)
+		}
+
+		ct.Values = values
+
+		return ct, nil
+	}
+
+	var nums []int
+	if len(args) > 0 {
+		for _, a := range strings.Split(args, ",") {
+			n, e := strconv.Atoi(strings.TrimSpace(a))
+
+			if e != nil {
+				return ColumnType{}, fmt.Errorf("cannot parse type: %s", t)
+			}
+
+			nums = append(nums, n)
+		}
+	}
+
+	switch ct.Kind {
+	case KindDecimal:
+		// decimal без параметров в mysql это decimal(10,0), в postgres - numeric без ограничений
+		if len(nums) > 0 {
+			ct.Precision = nums[0]
+		}
+		if len(nums) > 1 {
+			ct.Scale = nums[1]
+		}
+	case KindInt:
+		if ct.Base == "tinyint" && len(nums) == 1 && nums[0] == 1 {
+			ct.Kind = KindBool
+		}
+	case KindBit:
+		ct.Length = 1
+		if len(nums) > 0 {
+			ct.Length = nums[0]
+		}
+	case KindString, KindBinary:
+		if len(nums) > 0 {
+			ct.Length = nums[0]
+		}
+	}
+
+	return ct, nil
+}
+
+func parseEnumValues(args string) ([]string, error) {
+	var values []string
+
+	for len(args) > 0 {
+		if args[0] != '\'' {
+			return nil, fmt.Errorf("quote expected")
+		}
+
+		var sb strings.Builder
+		i := 1
+		for {
+			if i >= len(args) {
+				return nil, fmt.Errorf("unterminated value")
+			}
+
+			if args[i] == '\'' {
+				if i+1 < len(args) && args[i+1] == '\'' {
+					sb.WriteByte('\'')
+					i += 2
+
+					continue
+				}
+
+				break
+			}
+
+			sb.WriteByte(args[i])
+			i++
+		}
+
+		values = append(values, sb.String())
+		args = strings.TrimLeft(args[i+1:], " ")
+
+		if len(args) > 0 {
+			if args[0] != ',' {
+				return nil, fmt.Errorf("comma expected")
+			}
+			args = strings.TrimLeft(args[1:], " ")
+		}
+	}
+
+	return values, nil
+}
+
+func invalidType(name string) error {
+	return fmt.Errorf("field %s have invalid type", name)
+}
+
+func outOfRange(name string) error {
+	return fmt.Errorf("field %s is out of range", name)
+}
+
+// numberString достаёт текстовое представление числа из значения, пришедшего из json
+func numberString(val Any) (string, bool) {
+	switch v := val.(type) {
+	case json.Number:
+		return v.String(), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case int:
+		return strconv.Itoa(v), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	default:
+		return "", false
+	}
+}
+
+func parseInteger(s string) (*big.Int, bool) {
+	if i, ok := new(big.Int).SetString(s, 10); ok {
+		return i, true
+	}
+
+	// 4.0 и 1e3 - тоже целые
+	r, ok := new(big.Rat).SetString(s)
+	if !ok || !r.IsInt() {
+		return nil, false
+	}
+
+	return r.Num(), true
+}
+
+func ParseIntValue(val Any, name string, ct ColumnType) (Any, error) {
+	s, ok := numberString(val)
+	if !ok {
+		return nil, invalidType(name)
+	}
+
+	i, ok := parseInteger(s)
+	if !ok {
+		return nil, invalidType(name)
+	}
+
+	bits, ok := intBits[ct.Base]
+	if !ok {
+		bits = 64
+	}
+
+	var min, max *big.Int
+	if ct.Unsigned {
+		min = big.NewInt(0)
+		max = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), bits), big.NewInt(1))
+	} else {
+		max = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), bits-1), big.NewInt(1))
+		min = new(big.Int).Neg(new(big.Int).Lsh(big.NewInt(1), bits-1))
+	}
+
+	if i.Cmp(min) < 0 || i.Cmp(max) > 0 {
+		return nil, outOfRange(name)
+	}
+
+	if i.IsInt64() {
+		return i.Int64(), nil
+	}
+
+	return i.Uint64(), nil
+}
+
+func ParseBoolValue(val Any, name string) (Any, error) {
+	if b, ok := val.(bool); ok {
+		return b, nil
+	}
+
+	if s, ok := numberString(val); ok {
+		switch s {
+		case "0":
+			return false, nil
+		case "1":
+			return true, nil
+		}
+	}
+
+	return nil, invalidType(name)
+}
+
+func ParseDecimalValue(val Any, name string, ct ColumnType) (Any, error) {
+	s, ok := numberString(val)
+	if !ok {
+		if str, isStr := val.(string); isStr {
+			s = str
+		} else {
+			return nil, invalidType(name)
+		}
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, invalidType(name)
+	}
+
+	if ct.Unsigned && r.Sign() < 0 {
+		return nil, outOfRange(name)
+	}
+
+	if ct.Precision == 0 {
+		return s, nil
+	}
+
+	formatted := r.FloatString(ct.Scale)
+	intPart := strings.TrimLeft(strings.SplitN(formatted, ".", 2)[0], "-")
+	if len(strings.TrimLeft(intPart, "0")) > ct.Precision-ct.Scale {
+		return nil, outOfRange(name)
+	}
+
+	return formatted, nil
+}
+
+func ParseFloatValue(val Any, name string, ct ColumnType) (Any, error) {
+	s, ok := numberString(val)
+	if !ok {
+		return nil, invalidType(name)
+	}
+
+	f, e := strconv.ParseFloat(s, 64)
+	if e != nil {
+		return nil, outOfRange(name)
+	}
+
+	if ct.Unsigned && f < 0 {
+		return nil, outOfRange(name)
+	}
+
+	if ct.Base == "float" && math.Abs(f) > math.MaxFloat32 {
+		return nil, outOfRange(name)
+	}
+
+	return f, nil
+}
+
+func ParseStringValue(val Any, name string, ct ColumnType) (Any, error) {
+	s, ok := val.(string)
+	if !ok {
+		return nil, invalidType(name)
+	}
+
+	if ct.Length > 0 && utf8.RuneCountInString(s) > ct.Length {
+		return nil, fmt.Errorf("field %s is too long", name)
+	}
+
+	if ct.MaxBytes > 0 && len(s) > ct.MaxBytes {
+		return nil, fmt.Errorf("field %s is too long", name)
+	}
+
+	return s, nil
+}
+
+func ParseBinaryValue(val Any, name string, ct ColumnType) (Any, error) {
+	s, ok := val.(string)
+	if !ok {
+		return nil, invalidType(name)
+	}
+
+	b, e := base64.StdEncoding.DecodeString(s)
+	if e != nil {
+		return nil, fmt.Errorf("field %s must be base64 encoded", name)
+	}
+
+	if ct.Length > 0 && len(b) > ct.Length {
+		return nil, fmt.Errorf("field %s is too long", name)
+	}
+
+	if ct.MaxBytes > 0 && len(b) > ct.MaxBytes {
+		return nil, fmt.Errorf("field %s is too long", name)
+	}
+
+	return b, nil
+}
+
+const (
+	dateLayout     = "2006-01-02"
+	dateTimeLayout = "2006-01-02 15:04:05.999999"
+	timeLayout     = "15:04:05.999999"
+)
+
+var dateTimeInputLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+	dateLayout,
+}
+
+func ParseDateValue(val Any, name string) (Any, error) {
+	s, ok := val.(string)
+	if !ok {
+		return nil, invalidType(name)
+	}
+
+	t, e := time.Parse(dateLayout, s)
+	if e != nil {
+		return nil, fmt.Errorf("field %s must be a date in format YYYY-MM-DD", name)
+	}
+
+	return t.Format(dateLayout), nil
+}
+
+// ParseDateTimeValue принимает RFC3339 и привычный для mysql формат, в базу пишет UTC
+func ParseDateTimeValue(val Any, name string) (Any, error) {
+	s, ok := val.(string)
+	if !ok {
+		return nil, invalidType(name)
+	}
+
+	for _, layout := range dateTimeInputLayouts {
+		if t, e := time.Parse(layout, s); e == nil {
+			return t.UTC().Format(dateTimeLayout), nil
+		}
+	}
+
+	return nil, fmt.Errorf("field %s must be a RFC3339 datetime", name)
+}
+
+func ParseTimeValue(val Any, name string) (Any, error) {
+	s, ok := val.(string)
+	if !ok {
+		return nil, invalidType(name)
+	}
+
+	t, e := time.Parse(timeLayout, s)
+	if e != nil {
+		return nil, fmt.Errorf("field %s must be a time in format HH:MM:SS", name)
+	}
+
+	return t.Format(timeLayout), nil
+}
+
+func ParseYearValue(val Any, name string) (Any, error) {
+	s, ok := numberString(val)
+	if !ok {
+		return nil, invalidType(name)
+	}
+
+	y, e := strconv.Atoi(s)
+	if e != nil {
+		return nil, invalidType(name)
+	}
+
+	if y != 0 && (y < 1901 || y > 2155) {
+		return nil, outOfRange(name)
+	}
+
+	return int64(y), nil
+}
+
+func ParseJsonColumnValue(val Any, name string) (Any, error) {
+	b, e := json.Marshal(val)
+	if e != nil {
+		return nil, invalidType(name)
+	}
+
+	return string(b), nil
+}
+
+func ParseEnumValue(val Any, name string, ct ColumnType) (Any, error) {
+	s, ok := val.(string)
+	if !ok {
+		return nil, invalidType(name)
+	}
+
+	for _, v := range ct.Values {
+		if v == s {
+			return s, nil
+		}
+	}
+
+	return nil, fmt.Errorf("field %s must be one of: %s", name, strings.Join(ct.Values, ", "))
+}
+
+// ParseSetValue принимает как строку "a,b", так и массив ["a", "b"]
+func ParseSetValue(val Any, name string, ct ColumnType) (Any, error) {
+	var members []string
+
+	switch v := val.(type) {
+	case string:
+		if len(v) > 0 {
+			members = strings.Split(v, ",")
+		}
+	case []Any:
+		for _, m := range v {
+			s, ok := m.(string)
+			if !ok {
+				return nil, invalidType(name)
+			}
+			members = append(members, s)
+		}
+	default:
+		return nil, invalidType(name)
+	}
+
+	for _, m := range members {
+		if _, e := ParseEnumValue(m, name, ct); e != nil {
+			return nil, e
+		}
+	}
+
+	return strings.Join(members, ","), nil
+}
+
+func ParseBitValue(val Any, name string, ct ColumnType) (Any, error) {
+	s, ok := numberString(val)
+	if !ok {
+		return nil, invalidType(name)
+	}
+
+	i, ok := parseInteger(s)
+	if !ok {
+		return nil, invalidType(name)
+	}
+
+	if i.Sign() < 0 || i.BitLen() > ct.Length {
+		return nil, outOfRange(name)
+	}
+
+	return i.Uint64(), nil
+}
+
+// ParseByColumnType проверяет значение из json и приводит его к тому, что можно передать драйверу
+func ParseByColumnType(name string, ct ColumnType, val Any) (Any, error) {
+	switch ct.Kind {
+	case KindInt:
+		return ParseIntValue(val, name, ct)
+	case KindBool:
+		return ParseBoolValue(val, name)
+	case KindDecimal:
+		return ParseDecimalValue(val, name, ct)
+	case KindFloat:
+		return ParseFloatValue(val, name, ct)
+	case KindDate:
+		return ParseDateValue(val, name)
+	case KindDateTime:
+		return ParseDateTimeValue(val, name)
+	case KindTime:
+		return ParseTimeValue(val, name)
+	case KindYear:
+		return ParseYearValue(val, name)
+	case KindJSON:
+		return ParseJsonColumnValue(val, name)
+	case KindBinary:
+		return ParseBinaryValue(val, name, ct)
+	case KindEnum:
+		return ParseEnumValue(val, name, ct)
+	case KindSet:
+		return ParseSetValue(val, name, ct)
+	case KindBit:
+		return ParseBitValue(val, name, ct)
+	default:
+		return ParseStringValue(val, name, ct)
+	}
+}
+
+// ScanDest возвращает указатель, в который удобно сканировать значение колонки этого типа
+func (ct ColumnType) ScanDest() Any {
+	switch ct.Kind {
+	case KindInt:
+		if ct.Unsigned && ct.Base == "bigint" {
+			return new(sql.NullString)
+		}
+
+		return new(sql.NullInt64)
+	case KindYear:
+		return new(sql.NullInt64)
+	case KindBool:
+		// в tinyint(1) mysql можно записать любое число, истиной считается всё, кроме нуля
+		if ct.Base == "tinyint" {
+			return new(sql.NullInt64)
+		}
+
+		return new(sql.NullBool)
+	case KindFloat:
+		return new(sql.NullFloat64)
+	case KindBinary:
+		return new([]byte)
+	case KindDate, KindDateTime, KindTime, KindBit:
+		return new(Any)
+	default:
+		return new(sql.NullString)
+	}
+}
+
+// Render превращает просканированное значение в то, что отдаётся клиенту в json
+func (ct ColumnType) Render(dest Any) Any {
+	switch z := dest.(type) {
+	case *sql.NullInt64:
+		if !z.Valid {
+			return nil
+		}
+
+		if ct.Kind == KindBool {
+			return z.Int64 != 0
+		}
+
+		return z.Int64
+	case *sql.NullBool:
+		if !z.Valid {
+			return nil
+		}
+
+		return z.Bool
+	case *sql.NullFloat64:
+		if !z.Valid {
+			return nil
+		}
+
+		return z.Float64
+	case *[]byte:
+		if *z == nil {
+			return nil
+		}
+
+		// json сам закодирует []byte в base64
+		return *z
+	case *Any:
+		return ct.renderRaw(*z)
+	case *sql.NullString:
+		if !z.Valid {
+			return nil
+		}
+
+		return ct.renderString(z.String)
+	}
+
+	return dest
+}
+
+func (ct ColumnType) renderString(s string) Any {
+	switch ct.Kind {
+	case KindInt, KindDecimal:
+		return json.Number(s)
+	case KindJSON:
+		if json.Valid([]byte(s)) {
+			return json.RawMessage(s)
+		}
+
+		return s
+	case KindSet:
+		if len(s) == 0 {
+			return []string{}
+		}
+
+		return strings.Split(s, ",")
+	default:
+		return s
+	}
+}
+
+// renderRaw разбирает значения, которые разные драйверы отдают по-разному: time.Time, []byte или строкой
+func (ct ColumnType) renderRaw(v Any) Any {
+	if v == nil {
+		return nil
+	}
+
+	if ct.Kind == KindBit {
+		switch b := v.(type) {
+		case []byte:
+			var n uint64
+			for _, c := range b {
+				n = n<<8 | uint64(c)
+			}
+
+			return n
+		default:
+			return b
+		}
+	}
+
+	var t time.Time
+	switch tv := v.(type) {
+	case time.Time:
+		t = tv
+	case []byte:
+		return ct.renderTimeString(string(tv))
+	case string:
+		return ct.renderTimeString(tv)
+	default:
+		return tv
+	}
+
+	switch ct.Kind {
+	case KindDate:
+		return t.Format(dateLayout)
+	case KindTime:
+		return t.Format(timeLayout)
+	default:
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+}
+
+func (ct ColumnType) renderTimeString(s string) Any {
+	if ct.Kind != KindDateTime {
+		return s
+	}
+
+	for _, layout := range dateTimeInputLayouts {
+		if t, e := time.Parse(layout, s); e == nil {
+			return t.UTC().Format(time.RFC3339Nano)
+		}
+	}
+
+	// например нулевая дата 0000-00-00 00:00:00
+	return s
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseColumnType(t *testing.T) {
+	cases := []struct {
+		in   string
+		want ColumnType
+	}{
+		{"int(11)", ColumnType{Kind: KindInt, Base: "int"}},
+		{"bigint(20) unsigned", ColumnType{Kind: KindInt, Base: "bigint", Unsigned: true}},
+		{"bigint unsigned zerofill", ColumnType{Kind: KindInt, Base: "bigint", Unsigned: true}},
+		{"tinyint(1)", ColumnType{Kind: KindBool, Base: "tinyint"}},
+		{"varchar(64)", ColumnType{Kind: KindString, Base: "varchar", Length: 64}},
+		{"decimal(10,2)", ColumnType{Kind: KindDecimal, Base: "decimal", Precision: 10, Scale: 2}},
+		{"datetime(6)", ColumnType{Kind: KindDateTime, Base: "datetime"}},
+		{"enum('a','it''s')", ColumnType{Kind: KindEnum, Base: "enum", Values: []string{"a", "it's"}}},
+		{"set('x', 'y')", ColumnType{Kind: KindSet, Base: "set", Values: []string{"x", "y"}}},
+		{"bit(3)", ColumnType{Kind: KindBit, Base: "bit", Length: 3}},
+		{"UUID", ColumnType{Kind: KindString, Base: "uuid"}},
+	}
+
+	for _, c := range cases {
+		got, e := ParseColumnType(c.in)
+
+		if e != nil {
+			t.Errorf("ParseColumnType(%q): %v", c.in, e)
+
+			continue
+		}
+
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ParseColumnType(%q) = %+v, want %+v", c.in, got, c.want)
+		}
+	}
+
+	if _, e := ParseColumnType("enum('a"); e == nil {
+		t.Error("broken enum must not parse")
+	}
+}
+
+func TestParseByColumnType(t *testing.T) {
+	mustType := func(s string) ColumnType {
+		ct, e := ParseColumnType(s)
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		return ct
+	}
+
+	cases := []struct {
+		t    string
+		val  Any
+		want Any
+		err  string
+	}{
+		{"int(11)", json.Number("42"), int64(42), ""},
+		{"int(11)", json.Number("4.0"), int64(4), ""},
+		{"int(11)", json.Number("4.5"), nil, "field f have invalid type"},
+		{"tinyint", json.Number("200"), nil, "field f is out of range"},
+		{"tinyint unsigned", json.Number("200"), int64(200), ""},
+		{"bigint unsigned", json.Number("18446744073709551615"), uint64(18446744073709551615), ""},
+		{"int", "42", nil, "field f have invalid type"},
+		{"tinyint(1)", true, true, ""},
+		{"decimal(5,2)", json.Number("123.456"), "123.46", ""},
+		{"decimal(5,2)", json.Number("1234.5"), nil, "field f is out of range"},
+		{"varchar(3)", "абв", "абв", ""},
+		{"varchar(3)", "abcd", nil, "field f is too long"},
+		{"datetime", "2020-01-02T03:04:05+03:00", "2020-01-02 00:04:05", ""},
+		{"date", "2020-13-01", nil, "field f must be a date in format YYYY-MM-DD"},
+		{"json", map[string]Any{"a": json.Number("1")}, `{"a":1}`, ""},
+		{"blob", "aGk=", []byte("hi"), ""},
+		{"enum('a','b')", "c", nil, "field f must be one of: a, b"},
+		{"set('a','b')", []Any{"a", "b"}, "a,b", ""},
+		{"bit(2)", json.Number("4"), nil, "field f is out of range"},
+	}
+
+	for _, c := range cases {
+		got, e := ParseByColumnType("f", mustType(c.t), c.val)
+
+		if c.err != "" {
+			if e == nil || e.Error() != c.err {
+				t.Errorf("%s %v: error %v, want %s", c.t, c.val, e, c.err)
+			}
+
+			continue
+		}
+
+		if e != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s %v: got %#v (%v), want %#v", c.t, c.val, got, e, c.want)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	ct, _ := ParseColumnType("datetime")
+	var raw Any = []byte("2020-01-02 03:04:05")
+	if got := ct.Render(&raw); got != "2020-01-02T03:04:05Z" {
+		t.Errorf("datetime render: %v", got)
+	}
+
+	ct, _ = ParseColumnType("json")
+	got := ct.Render(&sql.NullString{String: `{"a":1}`, Valid: true})
+	if b, _ := json.Marshal(got); string(b) != `{"a":1}` {
+		t.Errorf("json render: %s", b)
+	}
+
+	ct, _ = ParseColumnType("bit(16)")
+	raw = []byte{1, 2}
+	if got := ct.Render(&raw); got != uint64(258) {
+		t.Errorf("bit render: %v", got)
+	}
+}
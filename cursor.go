@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Cursor - содержимое непрозрачного курсора: порядок сортировки и значения ключа граничной записи
+type Cursor struct {
+	Order  string `json:"o"`
+	Values []Any  `json:"v"`
+}
+
+func orderSignature(terms []OrderTerm) string {
+	parts := make([]string, len(terms))
+	for i, t := range terms {
+		parts[i] = t.Column + ".asc"
+		if t.Desc {
+			parts[i] = t.Column + ".desc"
+		}
+	}
+
+	return strings.Join(parts, ",")
+}
+
+func reverseOrder(terms []OrderTerm) []OrderTerm {
+	reversed := make([]OrderTerm, len(terms))
+	for i, t := range terms {
+		reversed[i] = OrderTerm{Column: t.Column, Desc: !t.Desc}
+	}
+
+	return reversed
+}
+
+// checkCursorOrder проверяет, что по ключу сортировки можно листать курсором: null-ы в ключе ломают сравнение
+func checkCursorOrder(terms []OrderTerm, columns []ColumnInfo) error {
+	for _, t := range terms {
+		column, _ := findColumn(columns, t.Column)
+
+		if column.Nullable {
+			return fmt.Errorf("cursor pagination requires not null order columns: %s", t.Column)
+		}
+	}
+
+	return nil
+}
+
+func EncodeCursor(terms []OrderTerm, record map[string]Any) (string, error) {
+	c := Cursor{Order: orderSignature(terms)}
+	for _, t := range terms {
+		c.Values = append(c.Values, record[t.Column])
+	}
+
+	b, e := json.Marshal(c)
+	if e != nil {
+		return "", e
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// DecodeCursor возвращает значения ключа, готовые для передачи в запрос
+func DecodeCursor(s string, terms []OrderTerm, columns []ColumnInfo) ([]Any, error) {
+	b, e := base64.RawURLEncoding.DecodeString(s)
+	if e != nil {
+		return nil, fmt.Errorf("bad cursor")
+	}
+
+	c := Cursor{}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if de := decoder.Decode(&c); de != nil {
+		return nil, fmt.Errorf("bad cursor")
+	}
+
+	if c.Order != orderSignature(terms) || len(c.Values) != len(terms) {
+		return nil, fmt.Errorf("cursor does not match order")
+	}
+
+	values := make([]Any, len(terms))
+	for i, t := range terms {
+		column, _ := findColumn(columns, t.Column)
+		v, pe := ParseByColumnType(column.Name, column.ColumnType, c.Values[i])
+
+		if pe != nil {
+			return nil, fmt.Errorf("bad cursor")
+		}
+
+		values[i] = v
+	}
+
+	return values, nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -39,90 +40,56 @@ func keys(m map[string]Any) []string {
 	return keys
 }
 
-func readTables(db *sql.DB) ([]string, error) {
-	rows, qe := db.Query("SHOW TABLES")
-
-	if qe != nil {
-		return nil, qe
-	}
-
-	var tables []string
-	for rows.Next() {
-		tableName := ""
-		se := rows.Scan(&tableName)
-
-		if se != nil {
-			return nil, se
-		}
-
-		tables = append(tables, tableName)
-	}
-	ce := rows.Close()
-
-	if ce != nil {
-		return nil, ce
-	}
-
-	return tables, nil
-}
-
 type ColumnInfo struct {
-	Name       string
-	Type       string
-	Nullable   bool
-	PrimaryKey bool
+	Name          string
+	Type          string
+	Nullable      bool
+	PrimaryKey    bool
+	AutoIncrement bool
+	Default       *string // выражение по умолчанию как его отдаёт СУБД, nil - нет значения по умолчанию
+	Comment       string
+	ColumnType    ColumnType
 }
 
 type Any = interface{}
 
 func (receiver *ColumnInfo) ParseFullColumn(scanArgs []Any) error {
-	// Field(0) Type(1) Null(3) Key(4)
+	// Field(0) Type(1) Null(3) Key(4) Default(5) Extra(6) Comment(8)
 	receiver.Name = *(scanArgs[0].(*string))
 	receiver.Type = *(scanArgs[1].(*string))
 	receiver.Nullable = *(scanArgs[3].(*string)) == "YES"
 	receiver.PrimaryKey = *(scanArgs[4].(*string)) == "PRI"
+	receiver.AutoIncrement = strings.Contains(fmt.Sprintf("%s", *(scanArgs[6].(*Any))), "auto_increment")
+	if dflt := *(scanArgs[5].(*Any)); dflt != nil {
+		d := fmt.Sprintf("%s", dflt)
+		receiver.Default = &d
+	}
+	if comment := *(scanArgs[8].(*Any)); comment != nil {
+		receiver.Comment = fmt.Sprintf("%s", comment)
+	}
 
 	return nil
 }
 
-func ParseIntValue(val Any, name string) (int, error) {
-	if i, ok := val.(int); ok {
-		return i, nil
-	} else {
-		return 0, fmt.Errorf("field %s have invalid type", name)
-	}
-}
+func (receiver *ColumnInfo) ParseType() error {
+	ct, e := ParseColumnType(receiver.Type)
 
-func ParseStringValue(val Any, name string) (string, error) {
-	if i, ok := val.(string); ok {
-		return i, nil
-	} else {
-		return "", fmt.Errorf("field %s have invalid type", name)
+	if e != nil {
+		return e
 	}
-}
 
-func ParseByColumnType(name, t string, val Any) (Any, error) {
-	switch t {
-	case "int":
-		return ParseIntValue(val, name)
-	case "varchar(255)":
-		fallthrough
-	case "text":
-		return ParseStringValue(val, name)
-	default:
-		return nil, fmt.Errorf("cannot parse type: %s", t)
-	}
+	receiver.ColumnType = ct
+
+	return nil
 }
 
 func (receiver *ColumnInfo) ParseJsonValue(body map[string]Any, ignoreMissing, ignorePk bool) (Any, bool, error) {
 	name := receiver.Name
 	nullable := receiver.Nullable
-	t := receiver.Type
+	t := receiver.ColumnType
 	pk := receiver.PrimaryKey
 	val, has := body[name]
 
-	fmt.Printf("name:%v, nullable:%v, t:%v, pk:%v, val:%v, has: %v\n", name, nullable, t, pk, val, has)
-
 	if has {
 		if pk {
 			if ignorePk {
@@ -150,87 +117,122 @@ func (receiver *ColumnInfo) ParseJsonValue(body map[string]Any, ignoreMissing, i
 	}
 }
 
-func readTypes(db *sql.DB, tableName string) ([]ColumnInfo, error) {
-	s := fmt.Sprintf("SHOW FULL COLUMNS FROM `%s`", tableName)
-	rows, qe := db.Query(s)
-
-	if qe != nil {
-		return nil, qe
-	}
+func NewDbExplorer(db *sql.DB) (http.Handler, error) {
+	return NewDbExplorerWithDialect(db, MySQLDialect{})
+}
 
-	var columns []ColumnInfo
-	for rows.Next() {
-		column := ColumnInfo{}
-		scanArgs := make([]interface{}, 9)
+func NewDbExplorerWithDialect(db *sql.DB, dialect Dialect) (http.Handler, error) {
+	return NewSchemaCache(db, dialect)
+}
 
-		scanArgs[0] = new(string)
-		scanArgs[1] = new(string)
-		scanArgs[2] = new(Any)
-		scanArgs[3] = new(string)
-		scanArgs[4] = new(string)
-		scanArgs[5] = new(Any)
-		scanArgs[6] = new(Any)
-		scanArgs[7] = new(Any)
-		scanArgs[8] = new(Any)
+// loadExplorer читает схему базы и собирает по ней всё, что нужно хендлерам
+func loadExplorer(db *sql.DB, dialect Dialect) (*DbExplorer, error) {
+	tableColumns := map[string][]ColumnInfo{}
+	tables, e := dialect.ReadTables(db)
 
-		se := rows.Scan(scanArgs...)
+	if e != nil {
+		return nil, e
+	}
 
-		if se != nil {
-			return nil, se
+	// служебные таблицы мигратора не отдаём через api: их правка сломает учёт миграций
+	userTables := tables[:0]
+	for _, t := range tables {
+		if !isMigrationsTable(t) {
+			userTables = append(userTables, t)
 		}
+	}
+	tables = userTables
 
-		pe := column.ParseFullColumn(scanArgs)
+	for _, t := range tables {
+		columnTypes, e := dialect.ReadColumns(db, t)
 
-		if pe != nil {
-			return nil, pe
+		if e != nil {
+			return nil, e
 		}
 
-		columns = append(columns, column)
-	}
-	ce := rows.Close()
+		for i := range columnTypes {
+			if pe := columnTypes[i].ParseType(); pe != nil {
+				return nil, pe
+			}
 
-	if ce != nil {
-		return nil, ce
-	}
+			if dialect.Name() == "mysql" {
+				columnTypes[i].ColumnType.MaxBytes = lengthLimits[columnTypes[i].ColumnType.Base]
+			}
+		}
 
-	return columns, nil
-}
+		tableColumns[t] = columnTypes
+	}
 
-func NewDbExplorer(db *sql.DB) (http.Handler, error) {
-	tableColumns := map[string][]ColumnInfo{}
-	tables, e := readTables(db)
+	foreignKeys := map[string][]ForeignKey{}
+	for _, t := range tables {
+		keys, e := dialect.ReadForeignKeys(db, t)
 
-	fmt.Printf("tables: %v\n", tables)
+		if e != nil {
+			return nil, e
+		}
 
-	if e != nil {
-		return nil, e
+		foreignKeys[t] = resolveForeignKeys(keys, tableColumns)
 	}
 
+	uniqueKeys := map[string][][]string{}
 	for _, t := range tables {
-		columnTypes, e := readTypes(db, t)
+		keys, e := dialect.ReadUniqueKeys(db, t)
 
 		if e != nil {
 			return nil, e
 		}
 
-		tableColumns[t] = columnTypes
+		uniqueKeys[t] = withPrimaryKey(keys, tableColumns[t])
 	}
 
-	for t, v := range tableColumns {
-		for _, ct := range v {
-			fmt.Printf("[%v][%v]=%v\n", t, ct.Name, ct)
+	indexes := map[string][]Index{}
+	for _, t := range tables {
+		list, e := dialect.ReadIndexes(db, t)
+
+		if e != nil {
+			return nil, e
 		}
+
+		indexes[t] = list
 	}
 
-	return &DbExplorer{
+	explorer := &DbExplorer{
 		db:          db,
+		dialect:     dialect,
+		builder:     QueryBuilder{dialect: dialect, schema: tableColumns},
 		columnTypes: tableColumns,
-	}, nil
+		foreignKeys: foreignKeys,
+		uniqueKeys:  uniqueKeys,
+		indexes:     indexes,
+	}
+
+	if explorer.openapi, e = explorer.buildOpenAPI(); e != nil {
+		return nil, e
+	}
+
+	overrides, e := readSchemaOverrides(SchemaOverridesFile)
+	if e != nil {
+		return nil, e
+	}
+
+	if explorer.jsonSchemas, e = explorer.buildJsonSchemas(overrides); e != nil {
+		return nil, e
+	}
+
+	return explorer, nil
 }
 
 type DbExplorer struct {
 	db          *sql.DB
+	dialect     Dialect
+	builder     QueryBuilder
 	columnTypes map[string][]ColumnInfo
+	foreignKeys map[string][]ForeignKey
+	uniqueKeys  map[string][][]string
+	indexes     map[string][]Index
+	openapi     []byte
+	jsonSchemas map[string]map[string]Any
+	cache       *SchemaCache
 }
 
 type ApiError struct {
@@ -273,6 +275,17 @@ func handleServerError(w http.ResponseWriter, httpStatus int, err error) {
 	}.Marshal())
 }
 
+// handleApiError отвечает клиенту ошибкой ApiError с её статусом, остальные ошибки считаются внутренними
+func handleApiError(w http.ResponseWriter, err error) {
+	if ae, ok := err.(ApiError); ok {
+		handleServerError(w, ae.HTTPStatus, ae.Err)
+
+		return
+	}
+
+	panic(err)
+}
+
 func handleServerResponse(w http.ResponseWriter, response interface{}) {
 	w.Write(ServerResponse{
 		Response: response,
@@ -294,6 +307,14 @@ func errorMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// decodeJsonBody разбирает тело запроса, сохраняя числа как json.Number, чтобы не терять точность bigint и decimal
+func decodeJsonBody(body []byte, v Any) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	return decoder.Decode(v)
+}
+
 func panicOnError(e error) {
 	if e != nil {
 		panic(e)
@@ -301,10 +322,12 @@ func panicOnError(e error) {
 }
 
 type RequestParams struct {
-	Table  string
-	Limit  int
-	Offset int
-	Id     int
+	Table    string
+	Limit    int
+	Offset   int
+	Id       string
+	Parent   string // /$parent/$parentId/$table - вложенная коллекция дочерних записей
+	ParentId string
 }
 
 func isRoot(url *url.URL) bool { return url.Path == "/" }
@@ -317,36 +340,57 @@ func isTwoSlashLong(url *url.URL) bool {
 	return len(url.Path) > 1 && strings.Count(url.Path, "/") == 2
 }
 
+func isThreeSlashLong(url *url.URL) bool {
+	return len(url.Path) > 1 && strings.Count(url.Path, "/") == 3
+}
+
 func (receiver *RequestParams) ParseRequestURL(url *url.URL) error {
 	noPrefixPath := strings.TrimPrefix(url.Path, "/")
 
 	if isOneSlashLong(url) {
 		receiver.Table = noPrefixPath
-		q := url.Query()
-		ls := q.Get("limit")
-		os := q.Get("offset")
-		l, e := strconv.Atoi(ls)
-		if len(ls) > 0 && e == nil {
-			receiver.Limit = l
-		}
-		o, e := strconv.Atoi(os)
-		if len(ls) > 0 && e == nil {
-			receiver.Offset = o
+		if le := receiver.parseLimitOffset(url); le != nil {
+			return le
 		}
-
 	} else if isTwoSlashLong(url) {
 		split := strings.Split(noPrefixPath, "/")
 		receiver.Table = split[0]
-		ids := split[1]
-		id, e := strconv.Atoi(ids)
-		if len(ids) > 0 && e == nil {
-			receiver.Id = id
+		receiver.Id = split[1]
+	} else if isThreeSlashLong(url) {
+		split := strings.Split(noPrefixPath, "/")
+		receiver.Parent = split[0]
+		receiver.ParentId = split[1]
+		receiver.Table = split[2]
+		if le := receiver.parseLimitOffset(url); le != nil {
+			return le
 		}
 	}
 
 	return nil
 }
 
+func (receiver *RequestParams) parseLimitOffset(url *url.URL) error {
+	q := url.Query()
+	ls := q.Get("limit")
+	os := q.Get("offset")
+	l, e := strconv.Atoi(ls)
+	if len(ls) > 0 && e == nil {
+		if l < 0 {
+			return fmt.Errorf("bad limit: %s", ls)
+		}
+		receiver.Limit = l
+	}
+	o, e := strconv.Atoi(os)
+	if len(os) > 0 && e == nil {
+		if o < 0 {
+			return fmt.Errorf("bad offset: %s", os)
+		}
+		receiver.Offset = o
+	}
+
+	return nil
+}
+
 func rowsToJson(infos []ColumnInfo, rows *sql.Rows) ([]interface{}, error) {
 	count := len(infos)
 	finalRows := make([]interface{}, 0, 10)
@@ -356,18 +400,7 @@ func rowsToJson(infos []ColumnInfo, rows *sql.Rows) ([]interface{}, error) {
 
 		// заполняем scanArgs указателями на соответсвующий тип
 		for i, v := range infos {
-			switch v.Type {
-			case "int":
-				scanArgs[i] = new(sql.NullInt64)
-				break
-			case "varchar(255)":
-				fallthrough
-			case "text":
-				scanArgs[i] = new(sql.NullString)
-				break
-			default:
-				scanArgs[i] = new(sql.NullString)
-			}
+			scanArgs[i] = v.ColumnType.ScanDest()
 		}
 
 		err := rows.Scan(scanArgs...)
@@ -380,26 +413,7 @@ func rowsToJson(infos []ColumnInfo, rows *sql.Rows) ([]interface{}, error) {
 
 		// на основе scanArgs раскладываем в мапу masterData правильные значения
 		for i, v := range infos {
-			if z, ok := (scanArgs[i]).(*sql.NullString); ok {
-				if z.Valid {
-					masterData[v.Name] = z.String
-				} else {
-					masterData[v.Name] = nil
-				}
-
-				continue
-			}
-
-			if z, ok := (scanArgs[i]).(*sql.NullInt64); ok {
-				if z.Valid {
-					masterData[v.Name] = z.Int64
-				} else {
-					masterData[v.Name] = nil
-				}
-				continue
-			}
-
-			masterData[v.Name] = scanArgs[i]
+			masterData[v.Name] = v.ColumnType.Render(scanArgs[i])
 		}
 
 		finalRows = append(finalRows, masterData)
@@ -422,6 +436,10 @@ func (explorer *DbExplorer) findPK(tableName string) (string, error) {
 	return "", fmt.Errorf("cannot find pk")
 }
 
+func pkFilter(pk string, id Any) Filter {
+	return Filter{Column: pk, Op: "eq", Values: []Any{id}}
+}
+
 func (explorer *DbExplorer) tableShouldExist(tableName string) error {
 	if _, exists := explorer.columnTypes[tableName]; exists {
 		return nil
@@ -443,9 +461,20 @@ func (explorer *DbExplorer) handleGetShowAllTables(w http.ResponseWriter, _ *htt
 }
 
 //GET /$table?limit=5&offset=7 - возвращает список из 5 записей (limit) начиная с 7-й (offset) из таблицы $table. limit по-умолчанию 5, offset 0
+//остальные параметры - фильтры по колонкам: ?title=eq.memcache&id=gt.1&updated=is.null&login=like.rv%
+//сортировка: ?order=updated.desc.nullslast,id.asc, по-умолчанию по primary key
+//выбор колонок: ?select=id,title
+//постраничный вывод курсором: ?after=<cursor>&limit=50 или ?before=<cursor>, пустой after - первая страница
+//?count=exact|estimated - добавить в ответ total, limit, offset и has_more
+//?embed=author,comments - вложить родительские и дочерние записи по внешним ключам
+//GET /$parent/$id/$table - то же самое, но только дочерние записи, ссылающиеся на $parent/$id
 func (explorer *DbExplorer) handleGetTableEntities(w http.ResponseWriter, r *http.Request) {
 	rp := &RequestParams{}
-	panicOnError(rp.ParseRequestURL(r.URL))
+	if pe := rp.ParseRequestURL(r.URL); pe != nil {
+		handleServerError(w, http.StatusBadRequest, pe)
+
+		return
+	}
 
 	if te := explorer.tableShouldExist(rp.Table); te != nil {
 		handleServerError(w, 404, te)
@@ -453,127 +482,576 @@ func (explorer *DbExplorer) handleGetTableEntities(w http.ResponseWriter, r *htt
 		return
 	}
 
+	parent, status, pe := explorer.parentFilter(rp)
+	if pe != nil {
+		handleServerError(w, status, pe)
+
+		return
+	}
+
 	if rp.Limit == 0 {
 		rp.Limit = 1000
 	}
 
-	rows, qe := explorer.db.Query(fmt.Sprintf("SELECT * FROM %s LIMIT %d OFFSET %d", rp.Table, rp.Limit, rp.Offset))
+	filters, fe := ParseFilters(r.URL.Query(), explorer.columnTypes[rp.Table])
+	if fe != nil {
+		handleServerError(w, http.StatusBadRequest, fe)
+
+		return
+	}
+
+	if parent != nil {
+		filters = append(filters, *parent)
+	}
+
+	columns, se := ParseSelect(r.URL.Query().Get("select"), explorer.columnTypes[rp.Table])
+	if se != nil {
+		handleServerError(w, http.StatusBadRequest, se)
+
+		return
+	}
+
+	order, oe := ParseOrder(r.URL.Query().Get("order"), explorer.columnTypes[rp.Table])
+	if oe != nil {
+		handleServerError(w, http.StatusBadRequest, oe)
+
+		return
+	}
+
+	embeds, ee := ParseEmbed(r.URL.Query().Get("embed"), explorer.relations(rp.Table), explorer.columnTypes[rp.Table])
+	if ee != nil {
+		handleServerError(w, http.StatusBadRequest, ee)
+
+		return
+	}
+
+	for _, pk := range explorer.findPKs(rp.Table) {
+		order = withTiebreaker(order, pk.Name)
+	}
+
+	q := r.URL.Query()
+	countMode := q.Get("count")
+	if countMode != "" && countMode != "exact" && countMode != "estimated" {
+		handleServerError(w, http.StatusBadRequest, fmt.Errorf("bad count: %s", countMode))
+
+		return
+	}
+
+	after, isAfter := q["after"]
+	before, isBefore := q["before"]
+	cursorMode := isAfter || isBefore
+	scanColumns := embedColumns(columns, explorer.columnTypes[rp.Table], embeds)
+	var cursorValues []Any
+
+	if cursorMode {
+		ce := explorer.prepareCursor(rp, order, isAfter && isBefore)
+		if ce != nil {
+			handleServerError(w, http.StatusBadRequest, ce)
+
+			return
+		}
+
+		var cursor string
+		if isAfter {
+			cursor = after[0]
+		} else {
+			cursor = before[0]
+		}
+		if len(cursor) > 0 {
+			cursorValues, ce = DecodeCursor(cursor, order, explorer.columnTypes[rp.Table])
+			if ce != nil {
+				handleServerError(w, http.StatusBadRequest, ce)
+
+				return
+			}
+		}
+
+		// колонки ключа нужны для курсоров, даже если их не просили в select
+		for _, t := range order {
+			if _, ok := findColumn(scanColumns, t.Column); !ok {
+				column, _ := findColumn(explorer.columnTypes[rp.Table], t.Column)
+				scanColumns = append(scanColumns[:len(scanColumns):len(scanColumns)], column)
+			}
+		}
+	}
+
+	queryOrder := order
+	if isBefore {
+		queryOrder = reverseOrder(order)
+	}
+
+	limit := rp.Limit
+	if cursorMode || countMode != "" {
+		// запрашиваем одну лишнюю запись, чтобы понять, есть ли следующая страница
+		limit++
+	}
+
+	sq := SelectQuery{
+		Table:   rp.Table,
+		Columns: columnNames(scanColumns),
+		Filters: filters,
+		Order:   queryOrder,
+		Limit:   limit,
+		Offset:  rp.Offset,
+	}
+	if cursorValues != nil {
+		sq.Keyset = &Keyset{Order: queryOrder, Values: cursorValues}
+	}
+
+	query, args, be := explorer.builder.Select(sq)
+	panicOnError(be)
+	rows, qe := explorer.db.Query(query, args...)
 	panicOnError(qe)
-	js, je := rowsToJson(explorer.columnTypes[rp.Table], rows)
-	panicOnError(je)
+	js, je := rowsToJson(scanColumns, rows)
+	if je != nil {
+		rows.Close()
+		panic(je)
+	}
 	panicOnError(rows.Close())
-	handleServerResponse(w, map[string]interface{}{
+
+	hasMore := len(js) > rp.Limit
+	if hasMore {
+		js = js[:rp.Limit]
+	}
+
+	panicOnError(explorer.embed(js, embeds))
+
+	response := map[string]interface{}{
 		"records": js,
-	})
+	}
+
+	if countMode != "" {
+		total, ce := explorer.countRows(rp.Table, countMode, filters)
+		panicOnError(ce)
+		response["total"] = total
+		response["limit"] = rp.Limit
+		response["offset"] = rp.Offset
+		response["has_more"] = hasMore
+	}
+
+	if !cursorMode {
+		stripColumns(js, scanColumns[len(columns):])
+		w.Header().Set("Content-Range", contentRange(rp.Offset, len(js), response["total"]))
+		handleServerResponse(w, response)
+
+		return
+	}
+
+	if isBefore {
+		for i, j := 0, len(js)-1; i < j; i, j = i+1, j-1 {
+			js[i], js[j] = js[j], js[i]
+		}
+	}
+
+	var nextCursor, prevCursor Any
+	if len(js) > 0 {
+		first, ee := EncodeCursor(order, js[0].(map[string]interface{}))
+		panicOnError(ee)
+		last, ee := EncodeCursor(order, js[len(js)-1].(map[string]interface{}))
+		panicOnError(ee)
+
+		if isAfter {
+			if hasMore {
+				nextCursor = last
+			}
+			if cursorValues != nil {
+				prevCursor = first
+			}
+		} else {
+			if hasMore {
+				prevCursor = first
+			}
+			if cursorValues != nil {
+				nextCursor = last
+			}
+		}
+	}
+
+	stripColumns(js, scanColumns[len(columns):])
+
+	response["next_cursor"] = nextCursor
+	response["prev_cursor"] = prevCursor
+	handleServerResponse(w, response)
+}
+
+// countRows считает строки под фильтром; estimated берёт оценку у диалекта, если она доступна
+func (explorer *DbExplorer) countRows(tableName, mode string, filters []Filter) (int64, error) {
+	if mode == "estimated" {
+		where, args, be := explorer.builder.Where(tableName, filters)
+		if be != nil {
+			return 0, be
+		}
+
+		estimate, ok, e := explorer.dialect.EstimateCount(explorer.db, tableName, where, args...)
+
+		if e == nil && ok {
+			return estimate, nil
+		}
+	}
+
+	query, args, be := explorer.builder.Count(tableName, filters)
+	if be != nil {
+		return 0, be
+	}
+
+	var total int64
+	e := explorer.db.QueryRow(query, args...).Scan(&total)
+
+	return total, e
+}
+
+// contentRange собирает заголовок вида "records 0-24/3573" или "records */0"
+func contentRange(offset, count int, total Any) string {
+	size := "*"
+	if total != nil {
+		size = fmt.Sprint(total)
+	}
+
+	if count == 0 {
+		return "records */" + size
+	}
+
+	return fmt.Sprintf("records %d-%d/%s", offset, offset+count-1, size)
+}
+
+// prepareCursor проверяет, что запрос можно листать курсором
+func (explorer *DbExplorer) prepareCursor(rp *RequestParams, order []OrderTerm, both bool) error {
+	if both {
+		return fmt.Errorf("after and before cannot be combined")
+	}
+
+	if rp.Offset != 0 {
+		return fmt.Errorf("offset cannot be combined with cursor")
+	}
+
+	if _, pke := explorer.findPK(rp.Table); pke != nil {
+		return pke
+	}
+
+	return checkCursorOrder(order, explorer.columnTypes[rp.Table])
 }
 
 //GET /$table/$id - возвращает информацию о самой записи или 404
+//?select=id,title - вернуть только перечисленные колонки
+//?embed=author,comments - вложить связанные записи
+//в ответе ETag по содержимому записи, If-None-Match с тем же тегом даёт 304
 func (explorer *DbExplorer) handleGetTableEntity(w http.ResponseWriter, r *http.Request) {
 	rp := &RequestParams{}
-	panicOnError(rp.ParseRequestURL(r.URL))
-	pk, e := explorer.findPK(rp.Table)
-	panicOnError(e)
-	rows, qe := explorer.db.Query(fmt.Sprintf("SELECT * FROM %s WHERE %s='%d'", rp.Table, pk, rp.Id))
+	if pe := rp.ParseRequestURL(r.URL); pe != nil {
+		handleServerError(w, http.StatusBadRequest, pe)
+
+		return
+	}
+
+	if te := explorer.tableShouldExist(rp.Table); te != nil {
+		handleServerError(w, http.StatusNotFound, te)
+
+		return
+	}
+
+	idFilters, ie := explorer.idFilters(rp)
+	if ie != nil {
+		handleServerError(w, http.StatusBadRequest, ie)
+
+		return
+	}
+
+	columns, se := ParseSelect(r.URL.Query().Get("select"), explorer.columnTypes[rp.Table])
+	if se != nil {
+		handleServerError(w, http.StatusBadRequest, se)
+
+		return
+	}
+
+	embeds, ee := ParseEmbed(r.URL.Query().Get("embed"), explorer.relations(rp.Table), explorer.columnTypes[rp.Table])
+	if ee != nil {
+		handleServerError(w, http.StatusBadRequest, ee)
+
+		return
+	}
+
+	scanColumns := withAllColumns(columns, explorer.columnTypes[rp.Table])
+	query, args, be := explorer.builder.Select(SelectQuery{
+		Table:   rp.Table,
+		Columns: columnNames(scanColumns),
+		Filters: idFilters,
+	})
+	panicOnError(be)
+	rows, qe := explorer.db.Query(query, args...)
 	panicOnError(qe)
-	js, je := rowsToJson(explorer.columnTypes[rp.Table], rows)
-	panicOnError(je)
+	js, je := rowsToJson(scanColumns, rows)
+	if je != nil {
+		rows.Close()
+		panic(je)
+	}
 	panicOnError(rows.Close())
 
-	if len(js) > 0 {
-		record := js[0]
-		handleServerResponse(w, map[string]interface{}{
-			"record": record,
-		})
-	} else {
+	if len(js) == 0 {
 		handleServerError(w, http.StatusNotFound, fmt.Errorf("record not found"))
+
+		return
+	}
+
+	etag := recordETag(js[0])
+	w.Header().Set("ETag", etag)
+	if inm := r.Header.Get("If-None-Match"); len(inm) > 0 && etagMatches(inm, etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+
+		return
 	}
+
+	panicOnError(explorer.embed(js, embeds))
+	stripColumns(js, scanColumns[len(columns):])
+
+	record := js[0]
+	handleServerResponse(w, map[string]interface{}{
+		"record": record,
+	})
 }
 
 //PUT /$table - создаёт новую запись, данный по записи в теле запроса (POST- параметры)
+//PUT /$parent/$id/$table - создаёт дочернюю запись, внешний ключ на родителя заполняется сам
+//?on_conflict=login или Prefer: resolution=merge-duplicates - upsert: при конфликте по уникальному ключу запись обновляется
+//Prefer: return=representation - вернуть в ответе и саму запись, как её сохранила база
 func (explorer *DbExplorer) handlePutTableEntity(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("PUT>")
 	rp := &RequestParams{}
-	panicOnError(rp.ParseRequestURL(r.URL))
+	if pe := rp.ParseRequestURL(r.URL); pe != nil {
+		handleServerError(w, http.StatusBadRequest, pe)
+
+		return
+	}
+
+	if te := explorer.tableShouldExist(rp.Table); te != nil {
+		handleServerError(w, http.StatusNotFound, te)
+
+		return
+	}
+
+	parent, status, pe := explorer.parentFilter(rp)
+	if pe != nil {
+		handleServerError(w, status, pe)
+
+		return
+	}
+
+	target, ce := explorer.conflictTarget(r, rp.Table)
+	if ce != nil {
+		handleServerError(w, http.StatusBadRequest, ce)
+
+		return
+	}
+
 	body, re := ioutil.ReadAll(r.Body)
 	panicOnError(re)
+
+	if isBulkBody(r, body) {
+		explorer.handleBulkInsert(w, r, rp, parent, target, body)
+
+		return
+	}
+
 	var data map[string]interface{}
-	fmt.Printf("body: %v\n", data)
-	ue := json.Unmarshal(body, &data)
-	panicOnError(ue)
+	if de := decodeJsonBody(body, &data); de != nil {
+		handleServerError(w, http.StatusBadRequest, fmt.Errorf("bad json"))
+
+		return
+	}
+
+	if violations := explorer.validateRecord(rp.Table, data, validateCreate, parent); len(violations) > 0 {
+		handleViolations(w, violations)
+
+		return
+	}
+
+	representation := wantsRepresentation(r)
+	if target != nil || representation {
+		kv, pe := explorer.upsertValues(rp.Table, data, parent, target)
+		if pe != nil {
+			handleServerError(w, http.StatusBadRequest, pe)
 
-	columnInfo := explorer.columnTypes[rp.Table]
+			return
+		}
+
+		tx, be := explorer.db.Begin()
+		panicOnError(be)
+		defer tx.Rollback()
+
+		ids, ie := explorer.insertMany(tx, rp.Table, []map[string]Any{kv}, target)
+		panicOnError(ie)
+
+		response := ids[0]
+		if representation {
+			response, ie = explorer.withRecord(tx, rp.Table, response)
+			panicOnError(ie)
+			preferenceApplied(w)
+		}
+		panicOnError(tx.Commit())
+
+		handleServerResponse(w, response)
+
+		return
+	}
+
+	kv, pe := explorer.insertValues(rp.Table, data, parent)
+	if pe != nil {
+		handleServerError(w, http.StatusBadRequest, pe)
+
+		return
+	}
+	autoPk := explorer.autoIncrementPk(rp.Table)
+
+	ks := keys(kv)
+	values := mapAny(ks, func(k string) Any { return kv[k] })
+	insert, args, be := explorer.builder.Insert(rp.Table, ks, values)
+	panicOnError(be)
+
+	if len(autoPk) > 0 {
+		lastInsertedId, ie := explorer.dialect.InsertReturningId(explorer.db, insert, autoPk, args...)
+		panicOnError(ie)
+		handleServerResponse(w, map[string]interface{}{autoPk: lastInsertedId})
+	} else {
+		_, ee := explorer.db.Exec(insert, args...)
+		panicOnError(ee)
+		handleServerResponse(w, explorer.pkValues(rp.Table, kv))
+	}
+}
+
+// insertValues разбирает тело записи в значения колонок для insert
+func (explorer *DbExplorer) insertValues(tableName string, data map[string]Any, parent *Filter) (map[string]Any, error) {
 	kv := make(map[string]Any, 5)
-	pk, pke := explorer.findPK(rp.Table)
-	panicOnError(pke)
 
-	for _, v := range columnInfo {
-		if v.PrimaryKey {
+	for _, v := range explorer.columnTypes[tableName] {
+		// автоинкрементный primary key игнорируется при вставке, остальные ключи (uuid, slug, составные) клиент передаёт сам
+		if v.AutoIncrement {
 			continue
 		}
 
 		name := v.Name
-		val, _, pe := v.ParseJsonValue(data, false, true)
-		panicOnError(pe)
+		if parent != nil && parent.Column == name {
+			kv[name] = parent.Values[0]
+
+			continue
+		}
+
+		val, _, pe := v.ParseJsonValue(data, v.PrimaryKey, false)
+		if pe != nil {
+			return nil, pe
+		}
 
 		if val != nil {
 			kv[name] = val
 		}
 	}
 
-	ks := keys(kv)
-	fmt.Printf("[kv]: %v\n", kv)
-	fmt.Printf("[ks]: %v\n", ks)
-	values := mapAny(ks, func(k string) Any { return kv[k] })
-	qs := maps(ks, func(k string) string { return "?" })
-	insert := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", rp.Table, strings.Join(ks, ", "), strings.Join(qs, ", "))
-	fmt.Println(insert)
-	result, ee := explorer.db.Exec(insert, values...)
-	panicOnError(ee)
-	lastInsertedId, ie := result.LastInsertId()
-	panicOnError(ie)
-	handleServerResponse(w, map[string]interface{}{pk: lastInsertedId})
-	fmt.Println("<PUT")
+	return kv, nil
 }
 
 //POST /$table/$id - обновляет запись, данные приходят в теле запроса (POST- параметры)
+//If-Match: "<etag>" - обновить, только если запись не менялась с момента чтения, иначе 412
 func (explorer *DbExplorer) handlePostTableEntity(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("POST>")
 	rp := &RequestParams{}
-	panicOnError(rp.ParseRequestURL(r.URL))
+	if pe := rp.ParseRequestURL(r.URL); pe != nil {
+		handleServerError(w, http.StatusBadRequest, pe)
+
+		return
+	}
+
+	if te := explorer.tableShouldExist(rp.Table); te != nil {
+		handleServerError(w, http.StatusNotFound, te)
+
+		return
+	}
+
 	body, re := ioutil.ReadAll(r.Body)
 	panicOnError(re)
 	var data map[string]interface{}
-	ue := json.Unmarshal(body, &data)
-	panicOnError(ue)
+	if de := decodeJsonBody(body, &data); de != nil {
+		handleServerError(w, http.StatusBadRequest, fmt.Errorf("bad json"))
+
+		return
+	}
 	//panicOnError(r.ParseForm())
 
-	columnInfo := explorer.columnTypes[rp.Table]
+	if violations := explorer.validateRecord(rp.Table, data, validateUpdate, nil); len(violations) > 0 {
+		handleViolations(w, violations)
+
+		return
+	}
+
+	idFilters, ie := explorer.idFilters(rp)
+	if ie != nil {
+		handleServerError(w, http.StatusBadRequest, ie)
+
+		return
+	}
+
+	kv, pe := explorer.updateValues(rp.Table, data)
+	if pe != nil {
+		handleServerError(w, http.StatusBadRequest, pe)
+
+		return
+	}
+
+	ifMatch := r.Header.Get("If-Match")
+	representation := wantsRepresentation(r)
+	if representation || len(ifMatch) > 0 {
+		tx, be := explorer.db.Begin()
+		panicOnError(be)
+		defer tx.Rollback()
+
+		if len(ifMatch) > 0 {
+			if me := explorer.checkIfMatch(tx, ifMatch, rp.Table, idFilters); me != nil {
+				handleApiError(w, me)
+
+				return
+			}
+		}
+
+		rowsAffected, ue := explorer.updateRows(tx, rp.Table, kv, idFilters)
+		panicOnError(ue)
+		record, re := explorer.readRecord(tx, rp.Table, idFilters)
+		panicOnError(re)
+		panicOnError(tx.Commit())
+
+		if record != nil {
+			w.Header().Set("ETag", recordETag(record))
+		}
+
+		response := map[string]interface{}{"updated": rowsAffected}
+		if representation {
+			preferenceApplied(w)
+			response["record"] = record
+		}
+		handleServerResponse(w, response)
+
+		return
+	}
+
+	rowsAffected, ue := explorer.updateRows(explorer.db, rp.Table, kv, idFilters)
+	panicOnError(ue)
+	handleServerResponse(w, map[string]interface{}{"updated": rowsAffected})
+}
+
+// updateValues разбирает тело запроса в значения колонок для update; отсутствующие колонки не трогаются
+func (explorer *DbExplorer) updateValues(tableName string, data map[string]Any) (map[string]Any, error) {
 	kv := make(map[string]Any, 5)
-	pk, pke := explorer.findPK(rp.Table)
-	panicOnError(pke)
 
-	fmt.Printf("[ParseBodyMapValue]\n")
-	fmt.Printf("data: %v\n", data)
-	for _, v := range columnInfo {
+	for _, v := range explorer.columnTypes[tableName] {
 		isPk := v.PrimaryKey
 		name := v.Name
 		val, has, pe := v.ParseJsonValue(data, true, false)
-		fmt.Printf("%v: %v\n", name, val)
 
 		if isPk {
-			if has && pe != nil {
-				handleServerError(w, http.StatusBadRequest, pe)
-
-				return
+			// primary key у существующей записи не обновляется
+			if has {
+				return nil, invalidType(name)
 			}
 
 			continue
 		}
 
 		if pe != nil {
-			handleServerError(w, http.StatusBadRequest, pe)
-
-			return
+			return nil, pe
 		}
 
 		if has {
@@ -581,65 +1059,155 @@ func (explorer *DbExplorer) handlePostTableEntity(w http.ResponseWriter, r *http
 		}
 	}
 
+	return kv, nil
+}
+
+// updateRows обновляет записи под фильтром и возвращает число изменённых строк
+func (explorer *DbExplorer) updateRows(q queryer, tableName string, kv map[string]Any, filters []Filter) (int64, error) {
 	ks := keys(kv)
 
 	if len(ks) == 0 {
-		handleServerResponse(w, map[string]interface{}{"updated": 0})
-
-		return
+		return 0, nil
 	}
 
 	values := mapAny(ks, func(k string) Any { return kv[k] })
-	subs := strings.Join(maps(ks, func(s string) string { return fmt.Sprintf("`%s`=?", s) }), ", ")
-	update := fmt.Sprintf("UPDATE %s SET %s WHERE %s='%d'", rp.Table, subs, pk, rp.Id)
-	fmt.Println(update)
-	fmt.Println(values)
-	result, ee := explorer.db.Exec(update, values...)
-	panicOnError(ee)
-	rowsAffected, ie := result.RowsAffected()
-	panicOnError(ie)
-	handleServerResponse(w, map[string]interface{}{"updated": rowsAffected})
-	fmt.Println("<POST")
+	update, args, be := explorer.builder.Update(tableName, ks, values, filters)
+	if be != nil {
+		return 0, be
+	}
+
+	result, ee := q.Exec(update, args...)
+	if ee != nil {
+		return 0, ee
+	}
+
+	return result.RowsAffected()
 }
 
 //DELETE /$table/$id - удаляет запись
+//If-Match: "<etag>" - удалить, только если запись не менялась, иначе 412
 func (explorer *DbExplorer) handleDeleteTableEntity(w http.ResponseWriter, r *http.Request) {
 	rp := &RequestParams{}
-	panicOnError(rp.ParseRequestURL(r.URL))
-	pk, pke := explorer.findPK(rp.Table)
-	panicOnError(pke)
-	result, ee := explorer.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s='%d'", rp.Table, pk, rp.Id))
-	panicOnError(ee)
-	affected, ae := result.RowsAffected()
-	panicOnError(ae)
+	if pe := rp.ParseRequestURL(r.URL); pe != nil {
+		handleServerError(w, http.StatusBadRequest, pe)
+
+		return
+	}
+
+	if te := explorer.tableShouldExist(rp.Table); te != nil {
+		handleServerError(w, http.StatusNotFound, te)
+
+		return
+	}
+
+	idFilters, ie := explorer.idFilters(rp)
+	if ie != nil {
+		handleServerError(w, http.StatusBadRequest, ie)
+
+		return
+	}
+
+	if ifMatch := r.Header.Get("If-Match"); len(ifMatch) > 0 {
+		tx, be := explorer.db.Begin()
+		panicOnError(be)
+		defer tx.Rollback()
+
+		if me := explorer.checkIfMatch(tx, ifMatch, rp.Table, idFilters); me != nil {
+			handleApiError(w, me)
+
+			return
+		}
+
+		affected, de := explorer.deleteRows(tx, rp.Table, idFilters)
+		panicOnError(de)
+		panicOnError(tx.Commit())
+		handleServerResponse(w, map[string]interface{}{
+			"deleted": affected,
+		})
+
+		return
+	}
+
+	affected, de := explorer.deleteRows(explorer.db, rp.Table, idFilters)
+	panicOnError(de)
 	handleServerResponse(w, map[string]interface{}{
 		"deleted": affected,
 	})
 }
 
+// deleteRows удаляет записи под фильтром и возвращает число удалённых строк
+func (explorer *DbExplorer) deleteRows(q queryer, tableName string, filters []Filter) (int64, error) {
+	query, args, be := explorer.builder.Delete(tableName, filters)
+	if be != nil {
+		return 0, be
+	}
+
+	result, ee := q.Exec(query, args...)
+	if ee != nil {
+		return 0, ee
+	}
+
+	return result.RowsAffected()
+}
+
 func (explorer *DbExplorer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	//GET / - возвращает список все таблиц (которые мы можем использовать в дальнейших запросах)
 	//GET /$table?limit=5&offset=7 - возвращает список из 5 записей (limit) начиная с 7-й (offset) из таблицы $table. limit по-умолчанию 5, offset 0
 	//GET /$table/$id - возвращает информацию о самой записи или 404
+	//GET /$parent/$id/$table - список дочерних записей
+	//GET /_schema, GET /$table/_schema - описание таблиц
+	//GET /_openapi.json - описание API
+	//GET /$table/_schema.json - JSON Schema записи
 	//PUT /$table - создаёт новую запись, данный по записи в теле запроса (POST- параметры)
+	//PUT /$parent/$id/$table - создаёт дочернюю запись
 	//POST /$table/$id - обновляет запись, данные приходят в теле запроса (POST- параметры)
 	//DELETE /$table/$id - удаляет запись
+	//PUT /$table/$id - заменяет запись целиком
+	//PATCH /$table/$id - применяет к записи merge patch или json patch
+	//PATCH /$table?filter - обновляет все записи под фильтром
+	//DELETE /$table?filter - удаляет все записи под фильтром
+	//PATCH /$parent/$id/$table, DELETE /$parent/$id/$table - то же для дочерних записей: без фильтра меняются все дети родителя
+	//POST /_batch - несколько операций в одной транзакции
+	//POST /_admin/reload-schema - перечитать схему базы
+	//POST /_schema/tables - создать таблицу
+	//PATCH /_schema/tables/$table - изменить колонки и индексы таблицы
+	//GET /_admin/migrations, POST /_admin/migrations/up, POST /_admin/migrations/down - миграции
 
 	switch r.Method {
 	case "GET":
 		if isRoot(r.URL) {
 			errorMiddleware(http.HandlerFunc(explorer.handleGetShowAllTables)).ServeHTTP(w, r)
+		} else if r.URL.Path == "/_openapi.json" {
+			errorMiddleware(http.HandlerFunc(explorer.handleGetOpenAPI)).ServeHTTP(w, r)
+		} else if r.URL.Path == "/_schema" {
+			errorMiddleware(http.HandlerFunc(explorer.handleGetSchema)).ServeHTTP(w, r)
+		} else if r.URL.Path == "/_admin/migrations" {
+			errorMiddleware(http.HandlerFunc(explorer.handleGetMigrations)).ServeHTTP(w, r)
+		} else if isTableJsonSchemaPath(r.URL.Path) {
+			errorMiddleware(http.HandlerFunc(explorer.handleGetTableJsonSchema)).ServeHTTP(w, r)
+		} else if isTableSchemaPath(r.URL.Path) {
+			errorMiddleware(http.HandlerFunc(explorer.handleGetTableSchema)).ServeHTTP(w, r)
 		} else if isOneSlashLong(r.URL) {
 			errorMiddleware(http.HandlerFunc(explorer.handleGetTableEntities)).ServeHTTP(w, r)
 		} else if isTwoSlashLong(r.URL) {
 			errorMiddleware(http.HandlerFunc(explorer.handleGetTableEntity)).ServeHTTP(w, r)
+		} else if isThreeSlashLong(r.URL) {
+			errorMiddleware(http.HandlerFunc(explorer.handleGetTableEntities)).ServeHTTP(w, r)
 		} else {
 			handleServerError(w, http.StatusNotAcceptable, fmt.Errorf("bad method"))
 
 		}
 		return
 	case "POST":
-		if isTwoSlashLong(r.URL) {
+		if r.URL.Path == "/_batch" {
+			errorMiddleware(http.HandlerFunc(explorer.handleBatch)).ServeHTTP(w, r)
+		} else if r.URL.Path == "/_admin/reload-schema" {
+			errorMiddleware(http.HandlerFunc(explorer.handleReloadSchema)).ServeHTTP(w, r)
+		} else if r.URL.Path == "/_schema/tables" {
+			errorMiddleware(http.HandlerFunc(explorer.handleCreateTable)).ServeHTTP(w, r)
+		} else if isMigrationsPath(r.URL.Path) {
+			errorMiddleware(http.HandlerFunc(explorer.handleMigrate)).ServeHTTP(w, r)
+		} else if isTwoSlashLong(r.URL) {
 			errorMiddleware(http.HandlerFunc(explorer.handlePostTableEntity)).ServeHTTP(w, r)
 		} else {
 			handleServerError(w, http.StatusNotAcceptable, fmt.Errorf("bad method"))
@@ -647,7 +1215,9 @@ func (explorer *DbExplorer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	case "PUT":
-		if isTwoSlashLong(r.URL) {
+		if isTwoSlashLong(r.URL) && !strings.HasSuffix(r.URL.Path, "/") {
+			errorMiddleware(http.HandlerFunc(explorer.handleReplaceTableEntity)).ServeHTTP(w, r)
+		} else if isOneSlashLong(r.URL) || isTwoSlashLong(r.URL) || isThreeSlashLong(r.URL) {
 			errorMiddleware(http.HandlerFunc(explorer.handlePutTableEntity)).ServeHTTP(w, r)
 		} else {
 			handleServerError(w, http.StatusNotAcceptable, fmt.Errorf("bad method"))
@@ -657,6 +1227,20 @@ func (explorer *DbExplorer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case "DELETE":
 		if isTwoSlashLong(r.URL) {
 			errorMiddleware(http.HandlerFunc(explorer.handleDeleteTableEntity)).ServeHTTP(w, r)
+		} else if isOneSlashLong(r.URL) || isThreeSlashLong(r.URL) {
+			errorMiddleware(http.HandlerFunc(explorer.handleDeleteTableEntities)).ServeHTTP(w, r)
+		} else {
+			handleServerError(w, http.StatusNotAcceptable, fmt.Errorf("bad method"))
+
+		}
+		return
+	case "PATCH":
+		if isSchemaTablePath(r.URL.Path) {
+			errorMiddleware(http.HandlerFunc(explorer.handleAlterTable)).ServeHTTP(w, r)
+		} else if isTwoSlashLong(r.URL) {
+			errorMiddleware(http.HandlerFunc(explorer.handlePatchTableEntity)).ServeHTTP(w, r)
+		} else if isOneSlashLong(r.URL) || isThreeSlashLong(r.URL) {
+			errorMiddleware(http.HandlerFunc(explorer.handlePatchTableEntities)).ServeHTTP(w, r)
 		} else {
 			handleServerError(w, http.StatusNotAcceptable, fmt.Errorf("bad method"))
 
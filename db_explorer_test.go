@@ -50,5 +50,10 @@ func TestTable(t *testing.T) {
 
 func TestTableId(t *testing.T) {
 	u, _ := url.Parse("https://host.com/$table/15")
-	UrlParsingTest(t, u, &RequestParams{Table: "$table", Id: 15}, nil)
+	UrlParsingTest(t, u, &RequestParams{Table: "$table", Id: "15"}, nil)
+}
+
+func TestNestedTable(t *testing.T) {
+	u, _ := url.Parse("https://host.com/users/1/items?limit=5")
+	UrlParsingTest(t, u, &RequestParams{Table: "items", Limit: 5, Parent: "users", ParentId: "1"}, nil)
 }
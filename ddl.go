@@ -0,0 +1,616 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ColumnSpec - декларативное описание колонки для DDL. Type пишется как в mysql (int, varchar(64), decimal(10,2),
+// tinyint(1), datetime, json, enum('a','b')), DDLBuilder переводит его на диалект базы
+type ColumnSpec struct {
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	Nullable      bool   `json:"nullable"`
+	Default       Any    `json:"default"` // литерал: строка, число или bool; null - без значения по умолчанию
+	PrimaryKey    bool   `json:"primary_key"`
+	AutoIncrement bool   `json:"auto_increment"`
+	Unique        bool   `json:"unique"`
+	Comment       string `json:"comment"`
+}
+
+// IndexSpec - индекс; без имени оно собирается из таблицы и колонок
+type IndexSpec struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+	Unique  bool     `json:"unique"`
+}
+
+type CreateTableSpec struct {
+	Name    string       `json:"name"`
+	Columns []ColumnSpec `json:"columns"`
+	Indexes []IndexSpec  `json:"indexes"`
+}
+
+// AlterTableSpec - изменения таблицы, применяются в порядке полей: переименование, удаление, изменение и добавление колонок, затем индексы
+type AlterTableSpec struct {
+	RenameColumns map[string]string `json:"rename_columns"`
+	DropColumns   []string          `json:"drop_columns"`
+	ModifyColumns []ColumnSpec      `json:"modify_columns"`
+	AddColumns    []ColumnSpec      `json:"add_columns"`
+	DropIndexes   []string          `json:"drop_indexes"`
+	AddIndexes    []IndexSpec       `json:"add_indexes"`
+}
+
+// DDLBuilder собирает DDL по декларативному описанию. Как и в QueryBuilder, имена квотируются диалектом,
+// а тип колонки собирается заново из разобранного ColumnType, поэтому в DDL не попадает ничего, кроме имён и литералов
+type DDLBuilder struct {
+	dialect Dialect
+	schema  map[string][]ColumnInfo
+}
+
+// ddlBases - типы, которые можно использовать в ColumnSpec
+var ddlBases = map[string]bool{
+	"tinyint": true, "smallint": true, "mediumint": true, "int": true, "integer": true, "bigint": true,
+	"decimal": true, "numeric": true, "float": true, "double": true, "real": true,
+	"char": true, "varchar": true, "tinytext": true, "text": true, "mediumtext": true, "longtext": true,
+	"binary": true, "varbinary": true, "tinyblob": true, "blob": true, "mediumblob": true, "longblob": true,
+	"date": true, "datetime": true, "timestamp": true, "time": true, "year": true,
+	"json": true, "enum": true, "set": true, "bool": true, "boolean": true, "uuid": true,
+}
+
+var postgresTypes = map[string]string{
+	"tinyint": "smallint", "smallint": "smallint", "mediumint": "integer", "int": "integer", "integer": "integer", "bigint": "bigint",
+	"float": "real", "real": "real", "double": "double precision",
+	"tinytext": "text", "text": "text", "mediumtext": "text", "longtext": "text",
+	"binary": "bytea", "varbinary": "bytea", "tinyblob": "bytea", "blob": "bytea", "mediumblob": "bytea", "longblob": "bytea",
+	"date": "date", "datetime": "timestamp", "timestamp": "timestamptz", "time": "time", "year": "smallint",
+	"json": "jsonb", "bool": "boolean", "boolean": "boolean", "uuid": "uuid",
+}
+
+func quoteLiteral(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// columnType переводит тип из ColumnSpec на диалект базы
+func (b DDLBuilder) columnType(c ColumnSpec) (string, error) {
+	ct, e := ParseColumnType(c.Type)
+	if e != nil || !ddlBases[ct.Base] {
+		return "", fmt.Errorf("unsupported type: %s", c.Type)
+	}
+
+	args := ""
+	switch ct.Kind {
+	case KindEnum, KindSet:
+		args = "(" + strings.Join(maps(ct.Values, quoteLiteral), ", ") + ")"
+	case KindDecimal:
+		if ct.Precision > 0 {
+			args = fmt.Sprintf("(%d,%d)", ct.Precision, ct.Scale)
+		}
+	case KindString, KindBinary, KindBit:
+		if ct.Length > 0 {
+			args = fmt.Sprintf("(%d)", ct.Length)
+		}
+	}
+
+	if b.dialect.Name() == "postgres" {
+		if ct.Unsigned {
+			return "", fmt.Errorf("unsigned is not supported by postgres: %s", c.Name)
+		}
+
+		switch ct.Base {
+		case "enum", "set":
+			return "", fmt.Errorf("%s is not supported by postgres: %s", ct.Base, c.Name)
+		case "decimal", "numeric":
+			return "numeric" + args, nil
+		case "char", "varchar":
+			if ct.Length == 0 {
+				return "text", nil
+			}
+
+			return ct.Base + args, nil
+		}
+
+		if c.AutoIncrement {
+			if ct.Kind != KindInt {
+				return "", fmt.Errorf("auto_increment requires an integer column: %s", c.Name)
+			}
+
+			return postgresTypes[ct.Base] + " GENERATED BY DEFAULT AS IDENTITY", nil
+		}
+
+		return postgresTypes[ct.Base], nil
+	}
+
+	// mysql и sqlite: sqlite хранит объявленный тип как есть, так что ReadColumns прочитает его обратно тем же
+	base := ct.Base
+	switch base {
+	case "bool", "boolean":
+		return "tinyint(1)", nil
+	case "uuid":
+		return "char(36)", nil
+	}
+
+	if ct.Kind == KindBool {
+		args = "(1)"
+	}
+
+	if ct.Unsigned {
+		args += " unsigned"
+	}
+
+	return base + args, nil
+}
+
+func (b DDLBuilder) literal(v Any) (string, error) {
+	switch t := v.(type) {
+	case string:
+		return quoteLiteral(t), nil
+	case bool:
+		if b.dialect.Name() == "postgres" {
+			return strings.ToUpper(strconv.FormatBool(t)), nil
+		}
+		if t {
+			return "1", nil
+		}
+
+		return "0", nil
+	default:
+		s, ok := numberString(v)
+		if !ok {
+			return "", fmt.Errorf("default must be a string, number or bool")
+		}
+
+		if _, e := strconv.ParseFloat(s, 64); e != nil {
+			return "", fmt.Errorf("default must be a string, number or bool")
+		}
+
+		return s, nil
+	}
+}
+
+// columnDefinition - колонка целиком для CREATE TABLE и ADD COLUMN. inlinePk - primary key из одной колонки,
+// sqlite умеет автоинкремент только у INTEGER PRIMARY KEY, объявленного прямо в колонке
+func (b DDLBuilder) columnDefinition(c ColumnSpec, inlinePk bool) (string, error) {
+	if len(c.Name) == 0 {
+		return "", fmt.Errorf("column name is required")
+	}
+
+	t, te := b.columnType(c)
+	if te != nil {
+		return "", te
+	}
+
+	parts := []string{b.dialect.QuoteIdent(c.Name), t}
+
+	if b.dialect.Name() == "sqlite" && c.AutoIncrement {
+		if !inlinePk {
+			return "", fmt.Errorf("auto_increment requires a single column primary key: %s", c.Name)
+		}
+
+		return b.dialect.QuoteIdent(c.Name) + " INTEGER PRIMARY KEY AUTOINCREMENT", nil
+	}
+
+	if !c.Nullable || c.PrimaryKey {
+		parts = append(parts, "NOT NULL")
+	}
+
+	if c.Default != nil {
+		if c.AutoIncrement {
+			return "", fmt.Errorf("auto_increment column cannot have a default: %s", c.Name)
+		}
+
+		l, le := b.literal(c.Default)
+		if le != nil {
+			return "", fmt.Errorf("%s: %v", c.Name, le)
+		}
+		parts = append(parts, "DEFAULT "+l)
+	}
+
+	if c.AutoIncrement && b.dialect.Name() == "mysql" {
+		parts = append(parts, "AUTO_INCREMENT")
+	}
+
+	if c.Unique {
+		parts = append(parts, "UNIQUE")
+	}
+
+	if len(c.Comment) > 0 && b.dialect.Name() == "mysql" {
+		parts = append(parts, "COMMENT "+quoteLiteral(c.Comment))
+	}
+
+	return strings.Join(parts, " "), nil
+}
+
+// columnComments - в postgres комментарий колонки ставится отдельной командой, в sqlite комментариев нет
+func (b DDLBuilder) columnComments(table string, columns []ColumnSpec) []string {
+	if b.dialect.Name() != "postgres" {
+		return nil
+	}
+
+	var ddl []string
+	for _, c := range columns {
+		if len(c.Comment) > 0 {
+			ddl = append(ddl, fmt.Sprintf("COMMENT ON COLUMN %s.%s IS %s",
+				b.dialect.QuoteIdent(table), b.dialect.QuoteIdent(c.Name), quoteLiteral(c.Comment)))
+		}
+	}
+
+	return ddl
+}
+
+func (b DDLBuilder) createIndex(table string, index IndexSpec, columns func(string) bool) (string, error) {
+	if len(index.Columns) == 0 {
+		return "", fmt.Errorf("index must have columns")
+	}
+
+	for _, c := range index.Columns {
+		if !columns(c) {
+			return "", fmt.Errorf("unknown column: %s", c)
+		}
+	}
+
+	name := index.Name
+	if len(name) == 0 {
+		name = table + "_" + strings.Join(index.Columns, "_") + "_idx"
+	}
+
+	unique := ""
+	if index.Unique {
+		unique = "UNIQUE "
+	}
+
+	return fmt.Sprintf("CREATE %sINDEX %s ON %s (%s)", unique, b.dialect.QuoteIdent(name), b.dialect.QuoteIdent(table),
+		strings.Join(maps(index.Columns, b.dialect.QuoteIdent), ", ")), nil
+}
+
+// CreateTable возвращает CREATE TABLE и команды для индексов и комментариев
+func (b DDLBuilder) CreateTable(spec CreateTableSpec) ([]string, error) {
+	if len(spec.Name) == 0 {
+		return nil, fmt.Errorf("table name is required")
+	}
+
+	if _, ok := b.schema[spec.Name]; ok {
+		return nil, fmt.Errorf("table already exists: %s", spec.Name)
+	}
+
+	if len(spec.Columns) == 0 {
+		return nil, fmt.Errorf("table must have columns")
+	}
+
+	var pks []string
+	names := map[string]bool{}
+	for _, c := range spec.Columns {
+		if names[c.Name] {
+			return nil, fmt.Errorf("duplicate column: %s", c.Name)
+		}
+		names[c.Name] = true
+
+		if c.PrimaryKey {
+			pks = append(pks, c.Name)
+		}
+	}
+
+	inlinePk := len(pks) == 1 && b.dialect.Name() == "sqlite"
+	definitions := make([]string, 0, len(spec.Columns)+1)
+	for _, c := range spec.Columns {
+		d, de := b.columnDefinition(c, inlinePk && c.PrimaryKey)
+		if de != nil {
+			return nil, de
+		}
+
+		// автоинкремент в sqlite уже объявил primary key в самой колонке
+		if inlinePk && c.PrimaryKey && c.AutoIncrement {
+			pks = nil
+		}
+		definitions = append(definitions, d)
+	}
+
+	if len(pks) > 0 {
+		definitions = append(definitions, "PRIMARY KEY ("+strings.Join(maps(pks, b.dialect.QuoteIdent), ", ")+")")
+	}
+
+	ddl := []string{fmt.Sprintf("CREATE TABLE %s (%s)", b.dialect.QuoteIdent(spec.Name), strings.Join(definitions, ", "))}
+	ddl = append(ddl, b.columnComments(spec.Name, spec.Columns)...)
+
+	for _, index := range spec.Indexes {
+		create, ie := b.createIndex(spec.Name, index, func(c string) bool { return names[c] })
+		if ie != nil {
+			return nil, ie
+		}
+		ddl = append(ddl, create)
+	}
+
+	return ddl, nil
+}
+
+// modifyColumn меняет тип, null, значение по умолчанию и комментарий существующей колонки.
+// wasAutoIncrement - была ли колонка автоинкрементной до изменения
+func (b DDLBuilder) modifyColumn(table string, c ColumnSpec, wasAutoIncrement bool) ([]string, error) {
+	quotedTable := b.dialect.QuoteIdent(table)
+
+	switch b.dialect.Name() {
+	case "mysql":
+		d, de := b.columnDefinition(c, false)
+		if de != nil {
+			return nil, de
+		}
+
+		return []string{fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s", quotedTable, d)}, nil
+	case "postgres":
+		// identity в postgres не часть типа: она добавляется и снимается отдельными подкомандами
+		plain := c
+		plain.AutoIncrement = false
+		t, te := b.columnType(plain)
+		if te != nil {
+			return nil, te
+		}
+
+		if c.AutoIncrement {
+			if ct, _ := ParseColumnType(c.Type); ct.Kind != KindInt {
+				return nil, fmt.Errorf("auto_increment requires an integer column: %s", c.Name)
+			}
+			if c.Default != nil {
+				return nil, fmt.Errorf("auto_increment column cannot have a default: %s", c.Name)
+			}
+		}
+
+		column := b.dialect.QuoteIdent(c.Name)
+		clauses := []string{fmt.Sprintf("ALTER COLUMN %s TYPE %s USING %s::%s", column, t, column, t)}
+
+		if c.Nullable && !c.AutoIncrement {
+			clauses = append(clauses, fmt.Sprintf("ALTER COLUMN %s DROP NOT NULL", column))
+		} else {
+			clauses = append(clauses, fmt.Sprintf("ALTER COLUMN %s SET NOT NULL", column))
+		}
+
+		if wasAutoIncrement && !c.AutoIncrement {
+			clauses = append(clauses, fmt.Sprintf("ALTER COLUMN %s DROP IDENTITY IF EXISTS", column))
+		}
+
+		if c.AutoIncrement {
+			// у автоинкрементной колонки значение по умолчанию - её последовательность, его не трогаем
+			if !wasAutoIncrement {
+				clauses = append(clauses, fmt.Sprintf("ALTER COLUMN %s ADD GENERATED BY DEFAULT AS IDENTITY", column))
+			}
+		} else if c.Default == nil {
+			clauses = append(clauses, fmt.Sprintf("ALTER COLUMN %s DROP DEFAULT", column))
+		} else {
+			l, le := b.literal(c.Default)
+			if le != nil {
+				return nil, fmt.Errorf("%s: %v", c.Name, le)
+			}
+			clauses = append(clauses, fmt.Sprintf("ALTER COLUMN %s SET DEFAULT %s", column, l))
+		}
+
+		ddl := []string{fmt.Sprintf("ALTER TABLE %s %s", quotedTable, strings.Join(clauses, ", "))}
+
+		return append(ddl, b.columnComments(table, []ColumnSpec{c})...), nil
+	default:
+		return nil, fmt.Errorf("%s cannot modify columns, recreate the table instead", b.dialect.Name())
+	}
+}
+
+// AlterTable возвращает команды для изменения существующей таблицы
+func (b DDLBuilder) AlterTable(table string, spec AlterTableSpec) ([]string, error) {
+	current, ok := b.schema[table]
+	if !ok {
+		return nil, fmt.Errorf("unknown table")
+	}
+
+	// колонки по мере применения изменений: переименованные и удалённые дальше не видны, добавленные - видны
+	columns := map[string]bool{}
+	for _, c := range current {
+		columns[c.Name] = true
+	}
+	has := func(name string) bool { return columns[name] }
+	quotedTable := b.dialect.QuoteIdent(table)
+
+	var ddl []string
+
+	// новое имя колонки -> имя в текущей схеме
+	renamedFrom := map[string]string{}
+	renames := make([]string, 0, len(spec.RenameColumns))
+	for from := range spec.RenameColumns {
+		renames = append(renames, from)
+	}
+	sort.Strings(renames)
+
+	for _, from := range renames {
+		to := spec.RenameColumns[from]
+		if !columns[from] {
+			return nil, fmt.Errorf("unknown column: %s", from)
+		}
+		if len(to) == 0 || columns[to] {
+			return nil, fmt.Errorf("bad new name for column %s: %q", from, to)
+		}
+
+		ddl = append(ddl, fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s", quotedTable, b.dialect.QuoteIdent(from), b.dialect.QuoteIdent(to)))
+		delete(columns, from)
+		columns[to] = true
+		renamedFrom[to] = from
+	}
+
+	for _, name := range spec.DropColumns {
+		if !columns[name] {
+			return nil, fmt.Errorf("unknown column: %s", name)
+		}
+
+		ddl = append(ddl, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", quotedTable, b.dialect.QuoteIdent(name)))
+		delete(columns, name)
+	}
+
+	for _, c := range spec.ModifyColumns {
+		if !columns[c.Name] {
+			return nil, fmt.Errorf("unknown column: %s", c.Name)
+		}
+
+		name := c.Name
+		if from, ok := renamedFrom[name]; ok {
+			name = from
+		}
+		was, _ := findColumn(current, name)
+
+		modify, me := b.modifyColumn(table, c, was.AutoIncrement)
+		if me != nil {
+			return nil, me
+		}
+		ddl = append(ddl, modify...)
+	}
+
+	for _, c := range spec.AddColumns {
+		if columns[c.Name] {
+			return nil, fmt.Errorf("column already exists: %s", c.Name)
+		}
+
+		if c.PrimaryKey || c.AutoIncrement {
+			return nil, fmt.Errorf("cannot add a primary key column: %s", c.Name)
+		}
+
+		d, de := b.columnDefinition(c, false)
+		if de != nil {
+			return nil, de
+		}
+
+		ddl = append(ddl, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", quotedTable, d))
+		ddl = append(ddl, b.columnComments(table, []ColumnSpec{c})...)
+		columns[c.Name] = true
+	}
+
+	for _, name := range spec.DropIndexes {
+		if b.dialect.Name() == "mysql" {
+			ddl = append(ddl, fmt.Sprintf("DROP INDEX %s ON %s", b.dialect.QuoteIdent(name), quotedTable))
+		} else {
+			ddl = append(ddl, fmt.Sprintf("DROP INDEX %s", b.dialect.QuoteIdent(name)))
+		}
+	}
+
+	for _, index := range spec.AddIndexes {
+		create, ie := b.createIndex(table, index, has)
+		if ie != nil {
+			return nil, ie
+		}
+		ddl = append(ddl, create)
+	}
+
+	if len(ddl) == 0 {
+		return nil, fmt.Errorf("nothing to change")
+	}
+
+	return ddl, nil
+}
+
+func isSchemaTablePath(path string) bool {
+	table := strings.TrimPrefix(path, "/_schema/tables/")
+
+	return table != path && len(table) > 0 && !strings.Contains(table, "/")
+}
+
+// execDDL выполняет команды в транзакции. Ошибка команды - ApiError с 400
+func (explorer *DbExplorer) execDDL(ddl []string) error {
+	tx, be := explorer.db.Begin()
+	if be != nil {
+		return be
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range ddl {
+		if _, e := tx.Exec(stmt); e != nil {
+			return ApiError{http.StatusBadRequest, fmt.Errorf("%s: %v", stmt, e)}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// applyDDL выполняет команды и перечитывает схему. С ?dry_run=1 только возвращает сгенерированные команды.
+// mysql фиксирует каждую DDL сама, и после ошибки выполненные до неё команды остаются, поэтому схема
+// перечитывается всегда. Ошибка перечитывания не отменяет результат DDL и отдаётся в reload_error
+func (explorer *DbExplorer) applyDDL(w http.ResponseWriter, r *http.Request, ddl []string) {
+	if isDryRun(r) {
+		handleServerResponse(w, map[string]interface{}{"ddl": ddl, "dry_run": true})
+
+		return
+	}
+
+	ee := explorer.execDDL(ddl)
+
+	response := map[string]interface{}{"ddl": ddl}
+	if re := explorer.cache.Reload(); re != nil {
+		response["reload_error"] = re.Error()
+	}
+
+	if ee != nil {
+		status := http.StatusInternalServerError
+		if ae, ok := ee.(ApiError); ok {
+			status, ee = ae.HTTPStatus, ae.Err
+		}
+
+		w.WriteHeader(status)
+		w.Write(ServerError{Error: ee.Error(), Response: response}.Marshal())
+
+		return
+	}
+
+	handleServerResponse(w, response)
+}
+
+//POST /_schema/tables - создаёт таблицу по CreateTableSpec в теле запроса
+func (explorer *DbExplorer) handleCreateTable(w http.ResponseWriter, r *http.Request) {
+	body, re := ioutil.ReadAll(r.Body)
+	panicOnError(re)
+
+	var spec CreateTableSpec
+	if de := decodeJsonBody(body, &spec); de != nil {
+		handleServerError(w, http.StatusBadRequest, fmt.Errorf("bad json"))
+
+		return
+	}
+
+	if _, ok := explorer.columnTypes[spec.Name]; ok {
+		handleServerError(w, http.StatusConflict, fmt.Errorf("table already exists: %s", spec.Name))
+
+		return
+	}
+
+	ddl, ce := DDLBuilder{dialect: explorer.dialect, schema: explorer.columnTypes}.CreateTable(spec)
+	if ce != nil {
+		handleServerError(w, http.StatusBadRequest, ce)
+
+		return
+	}
+
+	explorer.applyDDL(w, r, ddl)
+}
+
+//PATCH /_schema/tables/$table - меняет колонки и индексы таблицы по AlterTableSpec в теле запроса
+func (explorer *DbExplorer) handleAlterTable(w http.ResponseWriter, r *http.Request) {
+	table := strings.TrimPrefix(r.URL.Path, "/_schema/tables/")
+	if te := explorer.tableShouldExist(table); te != nil {
+		handleServerError(w, http.StatusNotFound, te)
+
+		return
+	}
+
+	body, re := ioutil.ReadAll(r.Body)
+	panicOnError(re)
+
+	var spec AlterTableSpec
+	if de := decodeJsonBody(body, &spec); de != nil {
+		handleServerError(w, http.StatusBadRequest, fmt.Errorf("bad json"))
+
+		return
+	}
+
+	ddl, ae := DDLBuilder{dialect: explorer.dialect, schema: explorer.columnTypes}.AlterTable(table, spec)
+	if ae != nil {
+		handleServerError(w, http.StatusBadRequest, ae)
+
+		return
+	}
+
+	explorer.applyDDL(w, r, ddl)
+}
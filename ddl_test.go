@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDDLBuilder(t *testing.T) {
+	spec := CreateTableSpec{
+		Name: "notes",
+		Columns: []ColumnSpec{
+			{Name: "id", Type: "bigint", PrimaryKey: true, AutoIncrement: true},
+			{Name: "price", Type: "decimal(10,2)", Default: json.Number("0")},
+			{Name: "body", Type: "text", Nullable: true, Comment: "note's text"},
+			{Name: "slug", Type: "varchar(32)", Unique: true},
+		},
+		Indexes: []IndexSpec{{Columns: []string{"price", "slug"}}},
+	}
+	alter := AlterTableSpec{
+		RenameColumns: map[string]string{"title": "name"},
+		ModifyColumns: []ColumnSpec{{Name: "id", Type: "bigint"}},
+		AddColumns:    []ColumnSpec{{Name: "done", Type: "bool", Default: true}},
+		DropIndexes:   []string{"items_title_idx"},
+	}
+
+	cases := []struct {
+		dialect Dialect
+		create  []string
+		alter   []string
+	}{
+		{
+			MySQLDialect{},
+			[]string{
+				"CREATE TABLE `notes` (`id` bigint NOT NULL AUTO_INCREMENT, `price` decimal(10,2) NOT NULL DEFAULT 0, " +
+					"`body` text COMMENT 'note''s text', `slug` varchar(32) NOT NULL UNIQUE, PRIMARY KEY (`id`))",
+				"CREATE INDEX `notes_price_slug_idx` ON `notes` (`price`, `slug`)",
+			},
+			[]string{
+				"ALTER TABLE `items` RENAME COLUMN `title` TO `name`",
+				"ALTER TABLE `items` MODIFY COLUMN `id` bigint NOT NULL",
+				"ALTER TABLE `items` ADD COLUMN `done` tinyint(1) NOT NULL DEFAULT 1",
+				"DROP INDEX `items_title_idx` ON `items`",
+			},
+		},
+		{
+			PostgresDialect{},
+			[]string{
+				`CREATE TABLE "notes" ("id" bigint GENERATED BY DEFAULT AS IDENTITY NOT NULL, "price" numeric(10,2) NOT NULL DEFAULT 0, ` +
+					`"body" text, "slug" varchar(32) NOT NULL UNIQUE, PRIMARY KEY ("id"))`,
+				`COMMENT ON COLUMN "notes"."body" IS 'note''s text'`,
+				`CREATE INDEX "notes_price_slug_idx" ON "notes" ("price", "slug")`,
+			},
+			[]string{
+				`ALTER TABLE "items" RENAME COLUMN "title" TO "name"`,
+				`ALTER TABLE "items" ALTER COLUMN "id" TYPE bigint USING "id"::bigint, ALTER COLUMN "id" SET NOT NULL, ALTER COLUMN "id" DROP DEFAULT`,
+				`ALTER TABLE "items" ADD COLUMN "done" boolean NOT NULL DEFAULT TRUE`,
+				`DROP INDEX "items_title_idx"`,
+			},
+		},
+	}
+
+	for _, c := range cases {
+		b := DDLBuilder{dialect: c.dialect, schema: testSchema()}
+
+		if ddl, e := b.CreateTable(spec); e != nil || !reflect.DeepEqual(ddl, c.create) {
+			t.Errorf("%s create:\nGot : %q %v\nWant: %q", c.dialect.Name(), ddl, e, c.create)
+		}
+
+		if ddl, e := b.AlterTable("items", alter); e != nil || !reflect.DeepEqual(ddl, c.alter) {
+			t.Errorf("%s alter:\nGot : %q %v\nWant: %q", c.dialect.Name(), ddl, e, c.alter)
+		}
+	}
+
+	identity := AlterTableSpec{ModifyColumns: []ColumnSpec{{Name: "id", Type: "bigint", AutoIncrement: true}}}
+	identityCases := []struct {
+		wasAutoIncrement bool
+		alter            []string
+	}{
+		{false, []string{`ALTER TABLE "items" ALTER COLUMN "id" TYPE bigint USING "id"::bigint, ALTER COLUMN "id" SET NOT NULL, ` +
+			`ALTER COLUMN "id" ADD GENERATED BY DEFAULT AS IDENTITY`}},
+		{true, []string{`ALTER TABLE "items" ALTER COLUMN "id" TYPE bigint USING "id"::bigint, ALTER COLUMN "id" SET NOT NULL`}},
+	}
+
+	for _, c := range identityCases {
+		schema := testSchema()
+		schema["items"][0].AutoIncrement = c.wasAutoIncrement
+		b := DDLBuilder{dialect: PostgresDialect{}, schema: schema}
+
+		if ddl, e := b.AlterTable("items", identity); e != nil || !reflect.DeepEqual(ddl, c.alter) {
+			t.Errorf("postgres identity:\nGot : %q %v\nWant: %q", ddl, e, c.alter)
+		}
+	}
+
+	schema := testSchema()
+	schema["items"][0].AutoIncrement = true
+	b := DDLBuilder{dialect: PostgresDialect{}, schema: schema}
+	dropIdentity := []string{`ALTER TABLE "items" ALTER COLUMN "id" TYPE bigint USING "id"::bigint, ALTER COLUMN "id" SET NOT NULL, ` +
+		`ALTER COLUMN "id" DROP IDENTITY IF EXISTS, ALTER COLUMN "id" DROP DEFAULT`}
+	if ddl, e := b.AlterTable("items", alter); e != nil || len(ddl) < 2 || !reflect.DeepEqual(ddl[1:2], dropIdentity) {
+		t.Errorf("postgres drop identity:\nGot : %q %v\nWant: %q", ddl, e, dropIdentity)
+	}
+
+	b = DDLBuilder{dialect: PostgresDialect{}, schema: testSchema()}
+	failures := []struct {
+		build func() ([]string, error)
+		error string
+	}{
+		{func() ([]string, error) { return b.CreateTable(CreateTableSpec{Name: "items", Columns: spec.Columns}) }, "table already exists: items"},
+		{func() ([]string, error) {
+			return b.CreateTable(CreateTableSpec{Name: "t", Columns: []ColumnSpec{{Name: "s", Type: "enum('a')"}}})
+		}, "enum is not supported by postgres: s"},
+		{func() ([]string, error) {
+			return b.CreateTable(CreateTableSpec{Name: "t", Columns: []ColumnSpec{{Name: "s", Type: "varchar(1) default 'x'"}}})
+		}, "unsupported type: varchar(1) default 'x'"},
+		{func() ([]string, error) {
+			return b.AlterTable("items", AlterTableSpec{DropColumns: []string{"missing"}})
+		}, "unknown column: missing"},
+		{func() ([]string, error) {
+			return b.AlterTable("items", AlterTableSpec{AddColumns: []ColumnSpec{{Name: "title", Type: "text"}}})
+		}, "column already exists: title"},
+		{func() ([]string, error) {
+			return b.AlterTable("items", AlterTableSpec{ModifyColumns: []ColumnSpec{{Name: "title", Type: "text", AutoIncrement: true}}})
+		}, "auto_increment requires an integer column: title"},
+		{func() ([]string, error) {
+			return b.AlterTable("items", AlterTableSpec{ModifyColumns: []ColumnSpec{{Name: "id", Type: "int", AutoIncrement: true, Default: json.Number("1")}}})
+		}, "auto_increment column cannot have a default: id"},
+		{func() ([]string, error) { return b.AlterTable("items", AlterTableSpec{}) }, "nothing to change"},
+	}
+
+	for _, f := range failures {
+		if _, e := f.build(); e == nil || e.Error() != f.error {
+			t.Errorf("got %v, want %s", e, f.error)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// queryer - общее подмножество *sql.DB и *sql.Tx, через которое ходят диалекты и хендлеры
+type queryer interface {
+	Exec(query string, args ...Any) (sql.Result, error)
+	Query(query string, args ...Any) (*sql.Rows, error)
+	QueryRow(query string, args ...Any) *sql.Row
+}
+
+// Dialect прячет всё, чем СУБД отличаются друг от друга:
+// чтение списка таблиц и колонок, квотирование идентификаторов, плейсхолдеры и получение id вставленной записи
+type Dialect interface {
+	Name() string
+	ReadTables(q queryer) ([]string, error)
+	ReadColumns(q queryer, tableName string) ([]ColumnInfo, error)
+	// ReadForeignKeys возвращает внешние ключи таблицы, составные ключи пропускаются
+	ReadForeignKeys(q queryer, tableName string) ([]ForeignKey, error)
+	// ReadUniqueKeys возвращает колонки уникальных индексов таблицы, каждый индекс - в порядке колонок
+	ReadUniqueKeys(q queryer, tableName string) ([][]string, error)
+	// ReadIndexes возвращает все индексы таблицы с колонками по порядку, у индекса по выражению колонка пустая
+	ReadIndexes(q queryer, tableName string) ([]Index, error)
+	QuoteIdent(name string) string
+	// Placeholder возвращает плейсхолдер для n-го (с единицы) аргумента запроса
+	Placeholder(n int) string
+	// InsertReturningId выполняет insert и возвращает значение автоинкрементного pk
+	InsertReturningId(q queryer, insert, pk string, args ...Any) (int64, error)
+	// InsertReturningIds выполняет многострочный insert из count строк и возвращает id в порядке строк
+	InsertReturningIds(q queryer, insert, pk string, count int, args ...Any) ([]int64, error)
+	// EstimateCount быстро оценивает число строк по статистике планировщика, where может быть пустым.
+	// Если оценка недоступна, возвращает false и считать придётся честно
+	EstimateCount(q queryer, tableName, where string, args ...Any) (int64, bool, error)
+	// SchemaChecksum - контрольная сумма описания таблиц, колонок и индексов: меняется после любого DDL
+	SchemaChecksum(q queryer) (string, error)
+}
+
+func DialectByName(driverName string) (Dialect, error) {
+	switch driverName {
+	case "mysql":
+		return MySQLDialect{}, nil
+	case "postgres", "pgx":
+		return PostgresDialect{}, nil
+	case "sqlite", "sqlite3":
+		return SQLiteDialect{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported driver: %s", driverName)
+	}
+}
+
+// readUniqueKeys собирает строки (index, column) в списки колонок по индексам, порядок строк сохраняется.
+// Индексы по выражениям запросы диалектов отсекают, но если такой всё же пришёл (колонка NULL), он пропускается
+func readUniqueKeys(q queryer, query string, args ...Any) ([][]string, error) {
+	rows, qe := q.Query(query, args...)
+
+	if qe != nil {
+		return nil, qe
+	}
+
+	var (
+		keys        [][]string
+		names       []string
+		indexes     = map[string]int{}
+		expressions = map[string]bool{}
+	)
+	for rows.Next() {
+		var (
+			index  string
+			column sql.NullString
+		)
+		se := rows.Scan(&index, &column)
+
+		if se != nil {
+			rows.Close()
+
+			return nil, se
+		}
+
+		if !column.Valid {
+			expressions[index] = true
+			continue
+		}
+
+		i, ok := indexes[index]
+		if !ok {
+			i = len(keys)
+			indexes[index] = i
+			keys = append(keys, nil)
+			names = append(names, index)
+		}
+		keys[i] = append(keys[i], column.String)
+	}
+
+	if ce := rows.Close(); ce != nil {
+		return nil, ce
+	}
+
+	unique := keys[:0]
+	for i, key := range keys {
+		if !expressions[names[i]] {
+			unique = append(unique, key)
+		}
+	}
+
+	return unique, nil
+}
+
+// readIndexes собирает строки (index, column, unique) в индексы, порядок строк сохраняется
+func readIndexes(q queryer, query string, args ...Any) ([]Index, error) {
+	rows, qe := q.Query(query, args...)
+
+	if qe != nil {
+		return nil, qe
+	}
+
+	var (
+		indexes []Index
+		names   = map[string]int{}
+	)
+	for rows.Next() {
+		var (
+			index  string
+			column sql.NullString
+			unique bool
+		)
+		se := rows.Scan(&index, &column, &unique)
+
+		if se != nil {
+			rows.Close()
+
+			return nil, se
+		}
+
+		i, ok := names[index]
+		if !ok {
+			i = len(indexes)
+			names[index] = i
+			indexes = append(indexes, Index{Name: index, Unique: unique})
+		}
+		indexes[i].Columns = append(indexes[i].Columns, column.String)
+	}
+
+	return indexes, rows.Close()
+}
+
+// checksumQueries считает sha256 по всем строкам всех запросов. Сумма считается на стороне сервера,
+// а не в базе: GROUP_CONCAT в mysql по умолчанию обрезается до 1024 байт
+func checksumQueries(q queryer, queries ...string) (string, error) {
+	h := sha256.New()
+
+	for _, query := range queries {
+		rows, qe := q.Query(query)
+		if qe != nil {
+			return "", qe
+		}
+
+		names, ne := rows.Columns()
+		if ne != nil {
+			rows.Close()
+
+			return "", ne
+		}
+
+		for rows.Next() {
+			values := make([]sql.NullString, len(names))
+			scanArgs := make([]Any, len(names))
+			for i := range values {
+				scanArgs[i] = &values[i]
+			}
+
+			if se := rows.Scan(scanArgs...); se != nil {
+				rows.Close()
+
+				return "", se
+			}
+
+			for _, v := range values {
+				fmt.Fprintf(h, "%v:%q,", v.Valid, v.String)
+			}
+			h.Write([]byte{'\n'})
+		}
+
+		if ce := rows.Close(); ce != nil {
+			return "", ce
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// returningIds выполняет insert ... RETURNING pk и собирает id всех вставленных строк
+func returningIds(q queryer, insert string, args ...Any) ([]int64, error) {
+	rows, qe := q.Query(insert, args...)
+
+	if qe != nil {
+		return nil, qe
+	}
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		se := rows.Scan(&id)
+
+		if se != nil {
+			rows.Close()
+
+			return nil, se
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Close()
+}
+
+// placeholders возвращает count плейсхолдеров, нумерация начинается с from
+func placeholders(d Dialect, from, count int) []string {
+	ret := make([]string, count)
+	for i := range ret {
+		ret[i] = d.Placeholder(from + i)
+	}
+
+	return ret
+}
+
+func quoteWith(name, quote string) string {
+	return quote + strings.ReplaceAll(name, quote, quote+quote) + quote
+}
+
+// explainRows достаёт колонку rows из первой строки результата EXPLAIN
+func explainRows(q queryer, query string, args ...Any) (int64, error) {
+	rows, qe := q.Query(query, args...)
+
+	if qe != nil {
+		return 0, qe
+	}
+
+	names, ne := rows.Columns()
+	if ne != nil {
+		rows.Close()
+
+		return 0, ne
+	}
+
+	var estimate int64
+	if rows.Next() {
+		scanArgs := make([]Any, len(names))
+		for i := range scanArgs {
+			scanArgs[i] = new(sql.NullString)
+		}
+
+		if se := rows.Scan(scanArgs...); se != nil {
+			rows.Close()
+
+			return 0, se
+		}
+
+		for i, name := range names {
+			if strings.EqualFold(name, "rows") {
+				estimate, _ = strconv.ParseInt(scanArgs[i].(*sql.NullString).String, 10, 64)
+			}
+		}
+	}
+
+	return estimate, rows.Close()
+}
+
+// readForeignKeys разбирает строки (constraint, column, ref table, ref column), общие для всех диалектов.
+// Составные ключи пропускаются: вложить по ним запись одной колонкой нельзя
+func readForeignKeys(q queryer, query string, args ...Any) ([]ForeignKey, error) {
+	rows, qe := q.Query(query, args...)
+
+	if qe != nil {
+		return nil, qe
+	}
+
+	var (
+		keys        []ForeignKey
+		constraints []string
+		sizes       = map[string]int{}
+	)
+	for rows.Next() {
+		var (
+			constraint string
+			fk         ForeignKey
+			refColumn  sql.NullString
+		)
+		se := rows.Scan(&constraint, &fk.Column, &fk.RefTable, &refColumn)
+
+		if se != nil {
+			rows.Close()
+
+			return nil, se
+		}
+
+		fk.RefColumn = refColumn.String
+		sizes[constraint]++
+		constraints = append(constraints, constraint)
+		keys = append(keys, fk)
+	}
+	ce := rows.Close()
+
+	if ce != nil {
+		return nil, ce
+	}
+
+	var single []ForeignKey
+	for i, fk := range keys {
+		if sizes[constraints[i]] == 1 {
+			single = append(single, fk)
+		}
+	}
+
+	return single, nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+type MySQLDialect struct{}
+
+func (MySQLDialect) Name() string { return "mysql" }
+
+func (MySQLDialect) QuoteIdent(name string) string { return quoteWith(name, "`") }
+
+func (MySQLDialect) Placeholder(int) string { return "?" }
+
+func (MySQLDialect) ReadTables(q queryer) ([]string, error) {
+	rows, qe := q.Query("SHOW TABLES")
+
+	if qe != nil {
+		return nil, qe
+	}
+
+	var tables []string
+	for rows.Next() {
+		tableName := ""
+		se := rows.Scan(&tableName)
+
+		if se != nil {
+			rows.Close()
+
+			return nil, se
+		}
+
+		tables = append(tables, tableName)
+	}
+	ce := rows.Close()
+
+	if ce != nil {
+		return nil, ce
+	}
+
+	return tables, nil
+}
+
+func (d MySQLDialect) ReadColumns(q queryer, tableName string) ([]ColumnInfo, error) {
+	s := fmt.Sprintf("SHOW FULL COLUMNS FROM %s", d.QuoteIdent(tableName))
+	rows, qe := q.Query(s)
+
+	if qe != nil {
+		return nil, qe
+	}
+
+	var columns []ColumnInfo
+	for rows.Next() {
+		column := ColumnInfo{}
+		scanArgs := make([]interface{}, 9)
+
+		scanArgs[0] = new(string)
+		scanArgs[1] = new(string)
+		scanArgs[2] = new(Any)
+		scanArgs[3] = new(string)
+		scanArgs[4] = new(string)
+		scanArgs[5] = new(Any)
+		scanArgs[6] = new(Any)
+		scanArgs[7] = new(Any)
+		scanArgs[8] = new(Any)
+
+		se := rows.Scan(scanArgs...)
+
+		if se != nil {
+			rows.Close()
+
+			return nil, se
+		}
+
+		pe := column.ParseFullColumn(scanArgs)
+
+		if pe != nil {
+			rows.Close()
+
+			return nil, pe
+		}
+
+		column.Type = strings.ToLower(column.Type)
+		columns = append(columns, column)
+	}
+	ce := rows.Close()
+
+	if ce != nil {
+		return nil, ce
+	}
+
+	return columns, nil
+}
+
+func (MySQLDialect) ReadForeignKeys(q queryer, tableName string) ([]ForeignKey, error) {
+	return readForeignKeys(q, `SELECT CONSTRAINT_NAME, COLUMN_NAME, REFERENCED_TABLE_NAME, REFERENCED_COLUMN_NAME
+FROM information_schema.KEY_COLUMN_USAGE
+WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND REFERENCED_TABLE_NAME IS NOT NULL
+ORDER BY CONSTRAINT_NAME, ORDINAL_POSITION`, tableName)
+}
+
+// ReadUniqueKeys: у функциональных частей индекса (mysql 8.0.13+) COLUMN_NAME NULL, такие индексы пропускаем
+func (MySQLDialect) ReadUniqueKeys(q queryer, tableName string) ([][]string, error) {
+	return readUniqueKeys(q, `SELECT INDEX_NAME, COLUMN_NAME FROM information_schema.STATISTICS
+WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND NON_UNIQUE = 0
+  AND INDEX_NAME NOT IN (SELECT INDEX_NAME FROM information_schema.STATISTICS
+    WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME IS NULL)
+ORDER BY INDEX_NAME, SEQ_IN_INDEX`, tableName, tableName)
+}
+
+func (MySQLDialect) ReadIndexes(q queryer, tableName string) ([]Index, error) {
+	return readIndexes(q, `SELECT INDEX_NAME, COLUMN_NAME, NON_UNIQUE = 0 FROM information_schema.STATISTICS
+WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?
+ORDER BY INDEX_NAME, SEQ_IN_INDEX`, tableName)
+}
+
+func (MySQLDialect) InsertReturningId(q queryer, insert, _ string, args ...Any) (int64, error) {
+	result, ee := q.Exec(insert, args...)
+
+	if ee != nil {
+		return 0, ee
+	}
+
+	return result.LastInsertId()
+}
+
+// InsertReturningIds: для многострочного insert mysql отдаёт id первой строки, остальные идут с шагом
+// auto_increment_increment (в galera и multi-primary он больше единицы). Подряд id идут, пока
+// innodb_autoinc_lock_mode не 2 или insert простой, а многострочный VALUES как раз простой
+func (MySQLDialect) InsertReturningIds(q queryer, insert, _ string, count int, args ...Any) ([]int64, error) {
+	var step int64
+	if se := q.QueryRow("SELECT @@auto_increment_increment").Scan(&step); se != nil {
+		return nil, se
+	}
+
+	result, ee := q.Exec(insert, args...)
+
+	if ee != nil {
+		return nil, ee
+	}
+
+	affected, ae := result.RowsAffected()
+	if ae != nil {
+		return nil, ae
+	}
+	if affected != int64(count) {
+		return nil, fmt.Errorf("inserted %d of %d rows, cannot tell their ids", affected, count)
+	}
+
+	first, le := result.LastInsertId()
+	if le != nil {
+		return nil, le
+	}
+
+	ids := make([]int64, count)
+	for i := range ids {
+		ids[i] = first + int64(i)*step
+	}
+
+	return ids, nil
+}
+
+func (d MySQLDialect) EstimateCount(q queryer, tableName, where string, args ...Any) (int64, bool, error) {
+	if len(where) > 0 {
+		estimate, e := explainRows(q, fmt.Sprintf("EXPLAIN SELECT * FROM %s WHERE %s", d.QuoteIdent(tableName), where), args...)
+
+		return estimate, e == nil, e
+	}
+
+	var estimate sql.NullInt64
+	e := q.QueryRow("SELECT TABLE_ROWS FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?", tableName).Scan(&estimate)
+
+	return estimate.Int64, e == nil && estimate.Valid, e
+}
+
+func (MySQLDialect) SchemaChecksum(q queryer) (string, error) {
+	return checksumQueries(q, `SELECT TABLE_NAME, COLUMN_NAME, COLUMN_TYPE, IS_NULLABLE, COLUMN_KEY, COLUMN_DEFAULT, EXTRA, COLUMN_COMMENT
+FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE()
+ORDER BY TABLE_NAME, ORDINAL_POSITION`, `SELECT TABLE_NAME, INDEX_NAME, SEQ_IN_INDEX, COLUMN_NAME, NON_UNIQUE
+FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = DATABASE()
+ORDER BY TABLE_NAME, INDEX_NAME, SEQ_IN_INDEX`, `SELECT CONSTRAINT_NAME, TABLE_NAME, COLUMN_NAME, REFERENCED_TABLE_NAME, REFERENCED_COLUMN_NAME
+FROM information_schema.KEY_COLUMN_USAGE WHERE TABLE_SCHEMA = DATABASE() AND REFERENCED_TABLE_NAME IS NOT NULL
+ORDER BY TABLE_NAME, CONSTRAINT_NAME, ORDINAL_POSITION`)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+type PostgresDialect struct{}
+
+func (PostgresDialect) Name() string { return "postgres" }
+
+func (PostgresDialect) QuoteIdent(name string) string { return quoteWith(name, `"`) }
+
+func (PostgresDialect) Placeholder(n int) string { return "$" + strconv.Itoa(n) }
+
+func (PostgresDialect) ReadTables(q queryer) ([]string, error) {
+	rows, qe := q.Query(`SELECT table_name FROM information_schema.tables
+WHERE table_schema = current_schema() AND table_type = 'BASE TABLE'
+ORDER BY table_name`)
+
+	if qe != nil {
+		return nil, qe
+	}
+
+	var tables []string
+	for rows.Next() {
+		tableName := ""
+		se := rows.Scan(&tableName)
+
+		if se != nil {
+			rows.Close()
+
+			return nil, se
+		}
+
+		tables = append(tables, tableName)
+	}
+	ce := rows.Close()
+
+	if ce != nil {
+		return nil, ce
+	}
+
+	return tables, nil
+}
+
+func (PostgresDialect) ReadColumns(q queryer, tableName string) ([]ColumnInfo, error) {
+	rows, qe := q.Query(`SELECT c.column_name, c.data_type, c.character_maximum_length, c.is_nullable,
+  EXISTS (
+    SELECT 1 FROM information_schema.table_constraints tc
+    JOIN information_schema.key_column_usage kcu
+      ON kcu.constraint_name = tc.constraint_name AND kcu.table_schema = tc.table_schema AND kcu.table_name = tc.table_name
+    WHERE tc.constraint_type = 'PRIMARY KEY' AND tc.table_schema = c.table_schema
+      AND tc.table_name = c.table_name AND kcu.column_name = c.column_name
+  ),
+  COALESCE(c.column_default LIKE 'nextval(%', false) OR c.is_identity = 'YES',
+  c.column_default,
+  col_description(to_regclass(quote_ident(c.table_schema) || '.' || quote_ident(c.table_name)), c.ordinal_position)
+FROM information_schema.columns c
+WHERE c.table_schema = current_schema() AND c.table_name = $1
+ORDER BY c.ordinal_position`, tableName)
+
+	if qe != nil {
+		return nil, qe
+	}
+
+	var columns []ColumnInfo
+	for rows.Next() {
+		var (
+			name, dataType, nullable string
+			length                   sql.NullInt64
+			pk, auto                 bool
+			dflt, comment            sql.NullString
+		)
+		se := rows.Scan(&name, &dataType, &length, &nullable, &pk, &auto, &dflt, &comment)
+
+		if se != nil {
+			rows.Close()
+
+			return nil, se
+		}
+
+		column := ColumnInfo{
+			Name:          name,
+			Type:          normalizePostgresType(dataType, length),
+			Nullable:      nullable == "YES",
+			PrimaryKey:    pk,
+			AutoIncrement: auto,
+			Comment:       comment.String,
+		}
+		if dflt.Valid {
+			column.Default = &dflt.String
+		}
+		columns = append(columns, column)
+	}
+	ce := rows.Close()
+
+	if ce != nil {
+		return nil, ce
+	}
+
+	return columns, nil
+}
+
+func (PostgresDialect) ReadForeignKeys(q queryer, tableName string) ([]ForeignKey, error) {
+	return readForeignKeys(q, `SELECT kcu.constraint_name, kcu.column_name, ref.table_name, ref.column_name
+FROM information_schema.table_constraints tc
+JOIN information_schema.key_column_usage kcu
+  ON kcu.constraint_name = tc.constraint_name AND kcu.table_schema = tc.table_schema AND kcu.table_name = tc.table_name
+JOIN information_schema.referential_constraints rc
+  ON rc.constraint_name = tc.constraint_name AND rc.constraint_schema = tc.table_schema
+JOIN information_schema.key_column_usage ref
+  ON ref.constraint_name = rc.unique_constraint_name AND ref.constraint_schema = rc.unique_constraint_schema
+  AND ref.ordinal_position = kcu.position_in_unique_constraint
+WHERE tc.constraint_type = 'FOREIGN KEY' AND tc.table_schema = current_schema() AND tc.table_name = $1
+ORDER BY kcu.constraint_name, kcu.ordinal_position`, tableName)
+}
+
+// ReadUniqueKeys: частичные индексы и индексы по выражениям не годятся как цель ON CONFLICT, их пропускаем
+func (PostgresDialect) ReadUniqueKeys(q queryer, tableName string) ([][]string, error) {
+	return readUniqueKeys(q, `SELECT i.relname, a.attname
+FROM pg_index x
+JOIN pg_class t ON t.oid = x.indrelid
+JOIN pg_class i ON i.oid = x.indexrelid
+JOIN pg_namespace n ON n.oid = t.relnamespace
+JOIN LATERAL unnest(x.indkey) WITH ORDINALITY AS k(attnum, ord) ON true
+JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = k.attnum
+WHERE x.indisunique AND x.indpred IS NULL AND x.indexprs IS NULL
+  AND n.nspname = current_schema() AND t.relname = $1
+ORDER BY i.relname, k.ord`, tableName)
+}
+
+// ReadIndexes: у индекса по выражению attnum = 0, такие колонки приходят пустыми
+func (PostgresDialect) ReadIndexes(q queryer, tableName string) ([]Index, error) {
+	return readIndexes(q, `SELECT i.relname, a.attname, x.indisunique
+FROM pg_index x
+JOIN pg_class t ON t.oid = x.indrelid
+JOIN pg_class i ON i.oid = x.indexrelid
+JOIN pg_namespace n ON n.oid = t.relnamespace
+JOIN LATERAL unnest(x.indkey) WITH ORDINALITY AS k(attnum, ord) ON true
+LEFT JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = k.attnum
+WHERE n.nspname = current_schema() AND t.relname = $1
+ORDER BY i.relname, k.ord`, tableName)
+}
+
+func (d PostgresDialect) InsertReturningId(q queryer, insert, pk string, args ...Any) (int64, error) {
+	var id int64
+	e := q.QueryRow(insert+" RETURNING "+d.QuoteIdent(pk), args...).Scan(&id)
+
+	return id, e
+}
+
+func (d PostgresDialect) InsertReturningIds(q queryer, insert, pk string, _ int, args ...Any) ([]int64, error) {
+	return returningIds(q, insert+" RETURNING "+d.QuoteIdent(pk), args...)
+}
+
+// normalizePostgresType приводит information_schema.columns.data_type к написанию, принятому в ColumnInfo.Type
+func normalizePostgresType(dataType string, length sql.NullInt64) string {
+	switch dataType {
+	case "integer":
+		return "int"
+	case "real":
+		return "float"
+	case "double precision":
+		return "double"
+	case "numeric":
+		return "decimal"
+	case "timestamp without time zone":
+		return "datetime"
+	case "timestamp with time zone":
+		return "timestamp"
+	case "jsonb":
+		return "json"
+	case "bytea":
+		return "blob"
+	case "character varying":
+		if length.Valid {
+			return fmt.Sprintf("varchar(%d)", length.Int64)
+		}
+
+		return "text"
+	case "character":
+		if length.Valid {
+			return fmt.Sprintf("char(%d)", length.Int64)
+		}
+
+		return "char(1)"
+	case "USER-DEFINED", "ARRAY":
+		// enum, типы расширений и массивы: драйвер отдаёт их текстом, так с ними и работаем
+		return "text"
+	default:
+		return dataType
+	}
+}
+
+func (d PostgresDialect) EstimateCount(q queryer, tableName, where string, args ...Any) (int64, bool, error) {
+	if len(where) > 0 {
+		var plan []struct {
+			Plan struct {
+				Rows float64 `json:"Plan Rows"`
+			} `json:"Plan"`
+		}
+		var raw []byte
+		e := q.QueryRow(fmt.Sprintf("EXPLAIN (FORMAT JSON) SELECT * FROM %s WHERE %s", d.QuoteIdent(tableName), where), args...).Scan(&raw)
+
+		if e != nil {
+			return 0, false, e
+		}
+
+		if je := json.Unmarshal(raw, &plan); je != nil || len(plan) == 0 {
+			return 0, false, je
+		}
+
+		return int64(plan[0].Plan.Rows), true, nil
+	}
+
+	// reltuples = -1 у таблицы, которую ещё ни разу не анализировали
+	var estimate float64
+	e := q.QueryRow("SELECT reltuples FROM pg_class WHERE oid = to_regclass($1)", d.QuoteIdent(tableName)).Scan(&estimate)
+
+	return int64(estimate), e == nil && estimate >= 0, e
+}
+
+func (PostgresDialect) SchemaChecksum(q queryer) (string, error) {
+	return checksumQueries(q, `SELECT c.table_name, c.column_name, c.data_type, c.character_maximum_length, c.is_nullable, c.column_default,
+  col_description(to_regclass(quote_ident(c.table_schema) || '.' || quote_ident(c.table_name)), c.ordinal_position)
+FROM information_schema.columns c
+WHERE c.table_schema = current_schema()
+ORDER BY c.table_name, c.ordinal_position`, `SELECT tablename, indexname, indexdef FROM pg_indexes
+WHERE schemaname = current_schema()
+ORDER BY tablename, indexname`, `SELECT conrelid::regclass::text, conname, pg_get_constraintdef(oid) FROM pg_constraint
+WHERE connamespace = current_schema()::regnamespace
+ORDER BY 1, 2`)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+)
+
+type SQLiteDialect struct{}
+
+func (SQLiteDialect) Name() string { return "sqlite" }
+
+func (SQLiteDialect) QuoteIdent(name string) string { return quoteWith(name, `"`) }
+
+func (SQLiteDialect) Placeholder(int) string { return "?" }
+
+func (SQLiteDialect) ReadTables(q queryer) ([]string, error) {
+	rows, qe := q.Query(`SELECT name FROM sqlite_master
+WHERE type = 'table' AND name NOT LIKE 'sqlite_%'
+ORDER BY name`)
+
+	if qe != nil {
+		return nil, qe
+	}
+
+	var tables []string
+	for rows.Next() {
+		tableName := ""
+		se := rows.Scan(&tableName)
+
+		if se != nil {
+			rows.Close()
+
+			return nil, se
+		}
+
+		tables = append(tables, tableName)
+	}
+	ce := rows.Close()
+
+	if ce != nil {
+		return nil, ce
+	}
+
+	return tables, nil
+}
+
+func (d SQLiteDialect) ReadColumns(q queryer, tableName string) ([]ColumnInfo, error) {
+	rows, qe := q.Query("PRAGMA table_info(" + d.QuoteIdent(tableName) + ")")
+
+	if qe != nil {
+		return nil, qe
+	}
+
+	var (
+		columns   []ColumnInfo
+		pkCount   int
+		integerPk = -1
+	)
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, t          string
+			dflt             sql.NullString
+		)
+		se := rows.Scan(&cid, &name, &t, &notNull, &dflt, &pk)
+
+		if se != nil {
+			rows.Close()
+
+			return nil, se
+		}
+
+		if pk > 0 {
+			pkCount++
+			if strings.EqualFold(t, "integer") {
+				integerPk = len(columns)
+			}
+		}
+
+		column := ColumnInfo{
+			Name: name,
+			Type: normalizeSQLiteType(t),
+			// INTEGER PRIMARY KEY в sqlite формально nullable, но null там превращается в rowid
+			Nullable:   notNull == 0 && pk == 0,
+			PrimaryKey: pk > 0,
+		}
+		if dflt.Valid {
+			column.Default = &dflt.String
+		}
+		columns = append(columns, column)
+	}
+	ce := rows.Close()
+
+	if ce != nil {
+		return nil, ce
+	}
+
+	// единственный INTEGER PRIMARY KEY - это алиас rowid, он заполняется сам
+	if pkCount == 1 && integerPk >= 0 {
+		columns[integerPk].AutoIncrement = true
+	}
+
+	return columns, nil
+}
+
+// ReadForeignKeys: пустой "to" в sqlite означает ссылку на primary key родительской таблицы
+func (SQLiteDialect) ReadForeignKeys(q queryer, tableName string) ([]ForeignKey, error) {
+	return readForeignKeys(q, `SELECT id, "from", "table", "to" FROM pragma_foreign_key_list(?) ORDER BY id, seq`, tableName)
+}
+
+// ReadUniqueKeys: INTEGER PRIMARY KEY индекса не имеет, primary key добавляет explorer.
+// У индекса по выражению имя колонки NULL, такие индексы уникальность колонок не задают
+func (SQLiteDialect) ReadUniqueKeys(q queryer, tableName string) ([][]string, error) {
+	return readUniqueKeys(q, `SELECT il.name, ii.name FROM pragma_index_list(?) il
+JOIN pragma_index_info(il.name) ii
+WHERE il."unique" = 1 AND il.partial = 0
+  AND NOT EXISTS (SELECT 1 FROM pragma_index_info(il.name) e WHERE e.name IS NULL)
+ORDER BY il.name, ii.seqno`, tableName)
+}
+
+// ReadIndexes: у INTEGER PRIMARY KEY индекса нет, в списке его не будет
+func (SQLiteDialect) ReadIndexes(q queryer, tableName string) ([]Index, error) {
+	return readIndexes(q, `SELECT il.name, ii.name, il."unique" FROM pragma_index_list(?) il
+JOIN pragma_index_info(il.name) ii
+ORDER BY il.name, ii.seqno`, tableName)
+}
+
+func (SQLiteDialect) InsertReturningId(q queryer, insert, _ string, args ...Any) (int64, error) {
+	result, ee := q.Exec(insert, args...)
+
+	if ee != nil {
+		return 0, ee
+	}
+
+	return result.LastInsertId()
+}
+
+// InsertReturningIds: last_insert_rowid после многострочного insert - id только последней строки, поэтому RETURNING
+func (d SQLiteDialect) InsertReturningIds(q queryer, insert, pk string, _ int, args ...Any) ([]int64, error) {
+	return returningIds(q, insert+" RETURNING "+d.QuoteIdent(pk), args...)
+}
+
+// normalizeSQLiteType приводит объявленный в CREATE TABLE тип к написанию, принятому в ColumnInfo.Type
+func normalizeSQLiteType(t string) string {
+	t = strings.ToLower(strings.TrimSpace(t))
+
+	switch t {
+	case "integer":
+		return "int"
+	case "real":
+		return "double"
+	case "":
+		return "blob"
+	default:
+		return t
+	}
+}
+
+// в sqlite нет статистики по числу строк, считаем честно
+func (SQLiteDialect) EstimateCount(queryer, string, string, ...Any) (int64, bool, error) {
+	return 0, false, nil
+}
+
+// SchemaChecksum: в sqlite_master лежит исходный DDL всех таблиц и индексов
+func (SQLiteDialect) SchemaChecksum(q queryer) (string, error) {
+	return checksumQueries(q, `SELECT type, name, tbl_name, sql FROM sqlite_master ORDER BY type, name`)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestQuoteIdent(t *testing.T) {
+	cases := []struct {
+		d    Dialect
+		name string
+		want string
+	}{
+		{MySQLDialect{}, "items", "`items`"},
+		{MySQLDialect{}, "it`ems", "`it``ems`"},
+		{PostgresDialect{}, "items", `"items"`},
+		{PostgresDialect{}, `it"ems`, `"it""ems"`},
+		{SQLiteDialect{}, `it"ems`, `"it""ems"`},
+	}
+
+	for _, c := range cases {
+		if got := c.d.QuoteIdent(c.name); got != c.want {
+			t.Errorf("%s.QuoteIdent(%q) = %q, want %q", c.d.Name(), c.name, got, c.want)
+		}
+	}
+}
+
+func TestPlaceholders(t *testing.T) {
+	if got := placeholders(PostgresDialect{}, 2, 3); got[0] != "$2" || got[2] != "$4" {
+		t.Errorf("postgres placeholders: %v", got)
+	}
+
+	if got := placeholders(MySQLDialect{}, 2, 2); got[0] != "?" || got[1] != "?" {
+		t.Errorf("mysql placeholders: %v", got)
+	}
+}
+
+func TestNormalizeTypes(t *testing.T) {
+	if got := normalizePostgresType("character varying", sql.NullInt64{Int64: 255, Valid: true}); got != "varchar(255)" {
+		t.Errorf("normalizePostgresType: %s", got)
+	}
+
+	if got := normalizePostgresType("integer", sql.NullInt64{}); got != "int" {
+		t.Errorf("normalizePostgresType: %s", got)
+	}
+
+	for _, dataType := range []string{"USER-DEFINED", "ARRAY"} {
+		if _, e := ParseColumnType(normalizePostgresType(dataType, sql.NullInt64{})); e != nil {
+			t.Errorf("normalizePostgresType(%s): %v", dataType, e)
+		}
+	}
+
+	if got := normalizeSQLiteType("INTEGER"); got != "int" {
+		t.Errorf("normalizeSQLiteType: %s", got)
+	}
+
+	if got := normalizeSQLiteType("VARCHAR(255)"); got != "varchar(255)" {
+		t.Errorf("normalizeSQLiteType: %s", got)
+	}
+}
+
+func TestDialectByName(t *testing.T) {
+	for _, name := range []string{"mysql", "postgres", "sqlite"} {
+		if _, e := DialectByName(name); e != nil {
+			t.Errorf("DialectByName(%s): %v", name, e)
+		}
+	}
+
+	if _, e := DialectByName("oracle"); e == nil {
+		t.Error("DialectByName(oracle) must fail")
+	}
+}
+
+// fakeMySQL - драйвер, который отвечает на insert как mysql с заданным auto_increment_increment
+type fakeMySQL struct {
+	step     int64
+	firstId  int64
+	affected int64
+}
+
+func (d *fakeMySQL) Open(string) (driver.Conn, error) { return fakeMySQLConn{d}, nil }
+
+type fakeMySQLConn struct{ d *fakeMySQL }
+
+func (c fakeMySQLConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeMySQLStmt{c.d, query}, nil
+}
+func (fakeMySQLConn) Close() error              { return nil }
+func (fakeMySQLConn) Begin() (driver.Tx, error) { return nil, fmt.Errorf("not supported") }
+
+type fakeMySQLStmt struct {
+	d     *fakeMySQL
+	query string
+}
+
+func (fakeMySQLStmt) Close() error  { return nil }
+func (fakeMySQLStmt) NumInput() int { return -1 }
+
+func (s fakeMySQLStmt) Exec([]driver.Value) (driver.Result, error) {
+	return fakeMySQLResult{s.d}, nil
+}
+
+func (s fakeMySQLStmt) Query([]driver.Value) (driver.Rows, error) {
+	if s.query != "SELECT @@auto_increment_increment" {
+		return nil, fmt.Errorf("unexpected query: %s", s.query)
+	}
+
+	return &fakeMySQLRows{values: []driver.Value{s.d.step}}, nil
+}
+
+type fakeMySQLResult struct{ d *fakeMySQL }
+
+func (r fakeMySQLResult) LastInsertId() (int64, error) { return r.d.firstId, nil }
+func (r fakeMySQLResult) RowsAffected() (int64, error) { return r.d.affected, nil }
+
+type fakeMySQLRows struct{ values []driver.Value }
+
+func (*fakeMySQLRows) Columns() []string { return []string{"@@auto_increment_increment"} }
+func (*fakeMySQLRows) Close() error      { return nil }
+
+func (r *fakeMySQLRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	dest[0], r.values = r.values[0], r.values[1:]
+
+	return nil
+}
+
+func TestMySQLInsertReturningIds(t *testing.T) {
+	fake := &fakeMySQL{step: 3, firstId: 7, affected: 3}
+	sql.Register("fakemysql", fake)
+
+	db, err := sql.Open("fakemysql", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ids, err := MySQLDialect{}.InsertReturningIds(db, "INSERT INTO items (title) VALUES (?), (?), (?)", "id", 3, "a", "b", "c")
+	if err != nil || !reflect.DeepEqual(ids, []int64{7, 10, 13}) {
+		t.Fatalf("auto_increment_increment = 3: %v %v", ids, err)
+	}
+
+	// если вставились не все строки, id остальных не угадать
+	fake.affected = 2
+	if _, err := (MySQLDialect{}).InsertReturningIds(db, "INSERT IGNORE INTO items (title) VALUES (?), (?), (?)", "id", 3, "a", "b", "c"); err == nil {
+		t.Fatal("partial insert must fail")
+	}
+}
+
+func TestSQLiteExpressionUniqueIndex(t *testing.T) {
+	db := OpenTestDB()
+	defer db.Close()
+
+	for _, stmt := range []string{
+		"CREATE TABLE u (id INTEGER PRIMARY KEY, email text, code text)",
+		"CREATE UNIQUE INDEX u_email ON u(lower(email))",
+		"CREATE UNIQUE INDEX u_code ON u(code)",
+	} {
+		if _, e := db.Exec(stmt); e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	// уникальность по выражению не делает колонку уникальной
+	if keys, e := (SQLiteDialect{}).ReadUniqueKeys(db, "u"); e != nil || !reflect.DeepEqual(keys, [][]string{{"code"}}) {
+		t.Errorf("unique keys: %v %v", keys, e)
+	}
+
+	if _, e := loadExplorer(db, SQLiteDialect{}); e != nil {
+		t.Errorf("loadExplorer: %v", e)
+	}
+}
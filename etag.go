@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// recordETag - сильный ETag по содержимому всех колонок записи. json.Marshal сортирует ключи, так что хеш стабилен
+func recordETag(record Any) string {
+	b, _ := json.Marshal(record)
+	sum := sha256.Sum256(b)
+
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches проверяет заголовок If-Match/If-None-Match со списком тегов. В If-Match сравнение строгое
+// и слабые теги W/"..." не совпадают ни с чем, в If-None-Match префикс W/ не учитывается
+func etagMatches(header, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+
+		if tag == "*" {
+			return true
+		}
+
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+
+		if tag == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// withAllColumns дописывает к выбранным колонкам все остальные: ETag считается по записи целиком, что бы ни просили в select
+func withAllColumns(selected, all []ColumnInfo) []ColumnInfo {
+	for _, c := range all {
+		if _, ok := findColumn(selected, c.Name); !ok {
+			selected = append(selected[:len(selected):len(selected)], c)
+		}
+	}
+
+	return selected
+}
+
+// checkIfMatch в транзакции блокирует запись и сверяет её ETag с If-Match. Отсутствующая запись тоже не проходит проверку
+func (explorer *DbExplorer) checkIfMatch(q queryer, ifMatch, tableName string, filters []Filter) error {
+	record, le := explorer.lockRecord(q, tableName, filters)
+	if le != nil {
+		return le
+	}
+
+	if record == nil || !etagMatches(ifMatch, recordETag(record), false) {
+		return ApiError{http.StatusPreconditionFailed, fmt.Errorf("precondition failed")}
+	}
+
+	return nil
+}
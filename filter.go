@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// служебные параметры списка, всё остальное в query считается фильтром по колонке
+var listParams = map[string]bool{
+	"limit":   true,
+	"offset":  true,
+	"order":   true,
+	"select":  true,
+	"after":   true,
+	"before":  true,
+	"count":   true,
+	"embed":   true,
+	"dry_run": true,
+}
+
+var filterOperators = map[string]string{
+	"eq":    "=",
+	"ne":    "<>",
+	"gt":    ">",
+	"gte":   ">=",
+	"lt":    "<",
+	"lte":   "<=",
+	"in":    "IN",
+	"like":  "LIKE",
+	"ilike": "LIKE",
+	"is":    "IS",
+}
+
+// Filter - условие вида ?title=eq.memcache, ?id=in.(1,2), ?updated=is.null
+type Filter struct {
+	Column string
+	Op     string
+	Values []Any
+}
+
+func findColumn(columns []ColumnInfo, name string) (ColumnInfo, bool) {
+	for _, c := range columns {
+		if c.Name == name {
+			return c, true
+		}
+	}
+
+	return ColumnInfo{}, false
+}
+
+// queryValue превращает строку из url в то, что пришло бы в json, чтобы провалидировать её через ParseByColumnType
+func queryValue(ct ColumnType, s string) Any {
+	switch ct.Kind {
+	case KindInt, KindDecimal, KindFloat, KindYear, KindBit:
+		return json.Number(s)
+	case KindBool:
+		switch s {
+		case "true":
+			return true
+		case "false":
+			return false
+		}
+
+		return json.Number(s)
+	default:
+		return s
+	}
+}
+
+func parseFilterValue(column ColumnInfo, s string) (Any, error) {
+	if column.ColumnType.Kind == KindJSON {
+		return s, nil
+	}
+
+	return ParseByColumnType(column.Name, column.ColumnType, queryValue(column.ColumnType, s))
+}
+
+func ParseFilter(column ColumnInfo, expr string) (Filter, error) {
+	split := strings.SplitN(expr, ".", 2)
+
+	if len(split) != 2 {
+		return Filter{}, fmt.Errorf("bad filter for %s: %s", column.Name, expr)
+	}
+
+	op, arg := split[0], split[1]
+	f := Filter{Column: column.Name, Op: op}
+
+	switch op {
+	case "eq", "ne", "gt", "gte", "lt", "lte":
+		v, e := parseFilterValue(column, arg)
+
+		if e != nil {
+			return Filter{}, e
+		}
+
+		f.Values = []Any{v}
+	case "in":
+		list := strings.TrimSuffix(strings.TrimPrefix(arg, "("), ")")
+
+		if len(list) == 0 {
+			return Filter{}, fmt.Errorf("bad filter for %s: %s", column.Name, expr)
+		}
+
+		for _, item := range strings.Split(list, ",") {
+			v, e := parseFilterValue(column, item)
+
+			if e != nil {
+				return Filter{}, e
+			}
+
+			f.Values = append(f.Values, v)
+		}
+	case "like", "ilike":
+		f.Values = []Any{arg}
+	case "is":
+		if arg != "null" && arg != "notnull" {
+			return Filter{}, fmt.Errorf("bad filter for %s: %s", column.Name, expr)
+		}
+
+		f.Values = []Any{arg}
+	default:
+		return Filter{}, fmt.Errorf("unknown filter operator: %s", op)
+	}
+
+	return f, nil
+}
+
+// ParseFilters разбирает все параметры query, кроме служебных, и сверяет их с колонками таблицы
+func ParseFilters(query url.Values, columns []ColumnInfo) ([]Filter, error) {
+	var names []string
+	for k := range query {
+		if !listParams[k] {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+
+	var filters []Filter
+	for _, name := range names {
+		column, ok := findColumn(columns, name)
+
+		if !ok {
+			return nil, fmt.Errorf("unknown column: %s", name)
+		}
+
+		for _, expr := range query[name] {
+			f, e := ParseFilter(column, expr)
+
+			if e != nil {
+				return nil, e
+			}
+
+			filters = append(filters, f)
+		}
+	}
+
+	return filters, nil
+}
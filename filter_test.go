@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestFiltersWhere(t *testing.T) {
+	columns := []ColumnInfo{
+		{Name: "id", Type: "int", PrimaryKey: true},
+		{Name: "title", Type: "varchar(255)"},
+		{Name: "updated", Type: "varchar(255)", Nullable: true},
+	}
+	for i := range columns {
+		if e := columns[i].ParseType(); e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	q, _ := url.ParseQuery("limit=5&title=ilike.mem%25&id=in.(1,2)&id=lt.10&updated=is.null")
+	filters, e := ParseFilters(q, columns)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	b := QueryBuilder{dialect: PostgresDialect{}, schema: map[string][]ColumnInfo{"items": columns}}
+	where, args, e := b.Where("items", filters)
+	if e != nil {
+		t.Fatal(e)
+	}
+	wantWhere := `"id" IN ($1, $2) AND "id" < $3 AND LOWER("title") LIKE LOWER($4) AND "updated" IS NULL`
+	if where != wantWhere {
+		t.Errorf("where:\n got %s\nwant %s", where, wantWhere)
+	}
+
+	wantArgs := []Any{int64(1), int64(2), int64(10), "mem%"}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("args: got %#v, want %#v", args, wantArgs)
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	column := ColumnInfo{Name: "id", Type: "int"}
+	column.ParseType()
+
+	for _, expr := range []string{"eq", "in.()", "is.empty", "nope.1", "gt.x"} {
+		if _, e := ParseFilter(column, expr); e == nil {
+			t.Errorf("ParseFilter(%q) must fail", expr)
+		}
+	}
+}
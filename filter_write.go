@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// writeFilters разбирает фильтры для массового изменения: без фильтра запрос затронул бы всю таблицу, поэтому он обязателен.
+// В /$parent/$id/$table фильтром считается условие на родителя: такой запрос меняет всех детей родителя
+func (explorer *DbExplorer) writeFilters(r *http.Request, rp *RequestParams) ([]Filter, int, error) {
+	if te := explorer.tableShouldExist(rp.Table); te != nil {
+		return nil, http.StatusNotFound, te
+	}
+
+	parent, status, pe := explorer.parentFilter(rp)
+	if pe != nil {
+		return nil, status, pe
+	}
+
+	filters, fe := ParseFilters(r.URL.Query(), explorer.columnTypes[rp.Table])
+	if fe != nil {
+		return nil, http.StatusBadRequest, fe
+	}
+
+	if parent != nil {
+		filters = append(filters, *parent)
+	}
+
+	if len(filters) == 0 {
+		return nil, http.StatusBadRequest, fmt.Errorf("filter is required")
+	}
+
+	return filters, 0, nil
+}
+
+func isDryRun(r *http.Request) bool {
+	v := r.URL.Query().Get("dry_run")
+
+	return v == "1" || v == "true"
+}
+
+// errTooManyRows - изменение затронуло больше MaxAffectedRows строк и откачено. Это ошибка запроса,
+// остальные ошибки writeInTx - ошибки базы
+var errTooManyRows = errors.New("too many rows affected")
+
+// writeInTx выполняет изменение в транзакции и откатывает его, если затронуто больше MaxAffectedRows строк
+func (explorer *DbExplorer) writeInTx(write func(q queryer) (int64, error)) (int64, error) {
+	tx, be := explorer.db.Begin()
+	if be != nil {
+		return 0, be
+	}
+	defer tx.Rollback()
+
+	affected, we := write(tx)
+	if we != nil {
+		return 0, we
+	}
+
+	if MaxAffectedRows > 0 && affected > MaxAffectedRows {
+		return affected, fmt.Errorf("%w: %d, limit is %d", errTooManyRows, affected, MaxAffectedRows)
+	}
+
+	return affected, tx.Commit()
+}
+
+func writeErrorStatus(e error) int {
+	if errors.Is(e, errTooManyRows) {
+		return http.StatusBadRequest
+	}
+
+	return http.StatusInternalServerError
+}
+
+//PATCH /$table?status=eq.draft - обновляет все записи под фильтром, данные в теле запроса. Фильтр обязателен
+//PATCH /$parent/$id/$table - обновляет все дочерние записи родителя, фильтры сужают выборку
+//?dry_run=1 - ничего не менять, только посчитать записи под фильтром
+func (explorer *DbExplorer) handlePatchTableEntities(w http.ResponseWriter, r *http.Request) {
+	rp := &RequestParams{}
+	if pe := rp.ParseRequestURL(r.URL); pe != nil {
+		handleServerError(w, http.StatusBadRequest, pe)
+
+		return
+	}
+
+	filters, status, fe := explorer.writeFilters(r, rp)
+	if fe != nil {
+		handleServerError(w, status, fe)
+
+		return
+	}
+
+	body, re := ioutil.ReadAll(r.Body)
+	panicOnError(re)
+	var data map[string]interface{}
+	if de := decodeJsonBody(body, &data); de != nil {
+		handleServerError(w, http.StatusBadRequest, fmt.Errorf("bad json"))
+
+		return
+	}
+
+	if violations := explorer.validateRecord(rp.Table, data, validateUpdate, nil); len(violations) > 0 {
+		handleViolations(w, violations)
+
+		return
+	}
+
+	kv, pe := explorer.updateValues(rp.Table, data)
+	if pe != nil {
+		handleServerError(w, http.StatusBadRequest, pe)
+
+		return
+	}
+
+	if isDryRun(r) {
+		total, ce := explorer.countRows(rp.Table, "exact", filters)
+		panicOnError(ce)
+		handleServerResponse(w, map[string]interface{}{"updated": total, "dry_run": true})
+
+		return
+	}
+
+	updated, ue := explorer.writeInTx(func(q queryer) (int64, error) {
+		return explorer.updateRows(q, rp.Table, kv, filters)
+	})
+	if ue != nil {
+		handleServerError(w, writeErrorStatus(ue), ue)
+
+		return
+	}
+
+	handleServerResponse(w, map[string]interface{}{"updated": updated})
+}
+
+//DELETE /$table?status=eq.spam - удаляет все записи под фильтром. Фильтр обязателен
+//DELETE /$parent/$id/$table - удаляет все дочерние записи родителя, фильтры сужают выборку
+//?dry_run=1 - ничего не удалять, только посчитать записи под фильтром
+func (explorer *DbExplorer) handleDeleteTableEntities(w http.ResponseWriter, r *http.Request) {
+	rp := &RequestParams{}
+	if pe := rp.ParseRequestURL(r.URL); pe != nil {
+		handleServerError(w, http.StatusBadRequest, pe)
+
+		return
+	}
+
+	filters, status, fe := explorer.writeFilters(r, rp)
+	if fe != nil {
+		handleServerError(w, status, fe)
+
+		return
+	}
+
+	if isDryRun(r) {
+		total, ce := explorer.countRows(rp.Table, "exact", filters)
+		panicOnError(ce)
+		handleServerResponse(w, map[string]interface{}{"deleted": total, "dry_run": true})
+
+		return
+	}
+
+	deleted, de := explorer.writeInTx(func(q queryer) (int64, error) {
+		return explorer.deleteRows(q, rp.Table, filters)
+	})
+	if de != nil {
+		handleServerError(w, writeErrorStatus(de), de)
+
+		return
+	}
+
+	handleServerResponse(w, map[string]interface{}{"deleted": deleted})
+}
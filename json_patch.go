@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// PatchOperation - одна операция JSON Patch (RFC 6902)
+type PatchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	From  string `json:"from"`
+	Value Any    `json:"value"`
+}
+
+// mergePatch применяет JSON Merge Patch (RFC 7396): null удаляет ключ, объекты сливаются рекурсивно, остальное заменяется
+func mergePatch(target, patch Any) Any {
+	p, ok := patch.(map[string]Any)
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]Any)
+	if !ok {
+		t = map[string]Any{}
+	}
+
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = mergePatch(t[k], v)
+		}
+	}
+
+	return t
+}
+
+// parsePointer разбирает JSON Pointer (RFC 6901) на ключи: "/a~1b/0" -> ["a/b", "0"]
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("bad pointer: %s", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(t, "~1", "/", -1), "~0", "~", -1)
+	}
+
+	return tokens, nil
+}
+
+// arrayIndex разбирает номер элемента массива; "-" допустим только при добавлении в конец
+func arrayIndex(token string, length int, insert bool) (int, error) {
+	if insert && token == "-" {
+		return length, nil
+	}
+
+	i, e := strconv.Atoi(token)
+	if e != nil || i < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("bad array index: %s", token)
+	}
+
+	if i > length || (!insert && i == length) {
+		return 0, fmt.Errorf("array index out of range: %s", token)
+	}
+
+	return i, nil
+}
+
+func pointerGet(doc Any, tokens []string) (Any, error) {
+	for _, t := range tokens {
+		switch container := doc.(type) {
+		case map[string]Any:
+			v, ok := container[t]
+			if !ok {
+				return nil, fmt.Errorf("path not found: %s", t)
+			}
+			doc = v
+		case []Any:
+			i, e := arrayIndex(t, len(container), false)
+			if e != nil {
+				return nil, e
+			}
+			doc = container[i]
+		default:
+			return nil, fmt.Errorf("path not found: %s", t)
+		}
+	}
+
+	return doc, nil
+}
+
+// pointerSet кладёт value по пути и возвращает изменённый документ. replace требует, чтобы значение по пути уже было,
+// без него в массив вставляется новый элемент, как в операции add
+func pointerSet(doc Any, tokens []string, value Any, replace bool) (Any, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	t, rest := tokens[0], tokens[1:]
+
+	switch container := doc.(type) {
+	case map[string]Any:
+		child, ok := container[t]
+		if (len(rest) > 0 || replace) && !ok {
+			return nil, fmt.Errorf("path not found: %s", t)
+		}
+
+		v, e := pointerSet(child, rest, value, replace)
+		if e != nil {
+			return nil, e
+		}
+		container[t] = v
+
+		return container, nil
+	case []Any:
+		if len(rest) == 0 && !replace {
+			i, e := arrayIndex(t, len(container), true)
+			if e != nil {
+				return nil, e
+			}
+
+			container = append(container, nil)
+			copy(container[i+1:], container[i:])
+			container[i] = value
+
+			return container, nil
+		}
+
+		i, e := arrayIndex(t, len(container), false)
+		if e != nil {
+			return nil, e
+		}
+
+		v, e := pointerSet(container[i], rest, value, replace)
+		if e != nil {
+			return nil, e
+		}
+		container[i] = v
+
+		return container, nil
+	default:
+		return nil, fmt.Errorf("path not found: %s", t)
+	}
+}
+
+func pointerRemove(doc Any, tokens []string) (Any, error) {
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("cannot remove the whole record")
+	}
+
+	t, rest := tokens[0], tokens[1:]
+
+	switch container := doc.(type) {
+	case map[string]Any:
+		child, ok := container[t]
+		if !ok {
+			return nil, fmt.Errorf("path not found: %s", t)
+		}
+
+		if len(rest) == 0 {
+			delete(container, t)
+
+			return container, nil
+		}
+
+		v, e := pointerRemove(child, rest)
+		if e != nil {
+			return nil, e
+		}
+		container[t] = v
+
+		return container, nil
+	case []Any:
+		i, e := arrayIndex(t, len(container), false)
+		if e != nil {
+			return nil, e
+		}
+
+		if len(rest) == 0 {
+			return append(container[:i], container[i+1:]...), nil
+		}
+
+		v, e := pointerRemove(container[i], rest)
+		if e != nil {
+			return nil, e
+		}
+		container[i] = v
+
+		return container, nil
+	default:
+		return nil, fmt.Errorf("path not found: %s", t)
+	}
+}
+
+// jsonEqual сравнивает значения как json: 1 и 1.0 равны, порядок ключей не важен
+func jsonEqual(a, b Any) bool {
+	var x, y Any
+	ab, _ := json.Marshal(a)
+	bb, _ := json.Marshal(b)
+	json.Unmarshal(ab, &x)
+	json.Unmarshal(bb, &y)
+
+	return reflect.DeepEqual(x, y)
+}
+
+// deepCopy копирует значение через json, чтобы copy не связывал два места документа
+func deepCopy(v Any) Any {
+	b, _ := json.Marshal(v)
+	var c Any
+	decodeJsonBody(b, &c)
+
+	return c
+}
+
+// applyJsonPatch применяет операции по порядку. Непрошедший test - 409, остальные ошибки - 400
+func applyJsonPatch(doc Any, ops []PatchOperation) (Any, error) {
+	for i, op := range ops {
+		path, pe := parsePointer(op.Path)
+		if pe != nil {
+			return nil, ApiError{http.StatusBadRequest, fmt.Errorf("operation %d: %v", i, pe)}
+		}
+
+		var e error
+		switch op.Op {
+		case "add":
+			doc, e = pointerSet(doc, path, op.Value, false)
+		case "remove":
+			doc, e = pointerRemove(doc, path)
+		case "replace":
+			doc, e = pointerSet(doc, path, op.Value, true)
+		case "move", "copy":
+			from, fe := parsePointer(op.From)
+			if fe != nil {
+				return nil, ApiError{http.StatusBadRequest, fmt.Errorf("operation %d: %v", i, fe)}
+			}
+
+			var v Any
+			if v, e = pointerGet(doc, from); e != nil {
+				break
+			}
+
+			if op.Op == "move" {
+				if strings.HasPrefix(op.Path+"/", op.From+"/") && op.Path != op.From {
+					e = fmt.Errorf("cannot move into itself: %s", op.From)
+
+					break
+				}
+				if doc, e = pointerRemove(doc, from); e != nil {
+					break
+				}
+			} else {
+				v = deepCopy(v)
+			}
+
+			doc, e = pointerSet(doc, path, v, false)
+		case "test":
+			v, ge := pointerGet(doc, path)
+			if ge != nil || !jsonEqual(v, op.Value) {
+				return nil, ApiError{http.StatusConflict, fmt.Errorf("operation %d: test failed: %s", i, op.Path)}
+			}
+		default:
+			e = fmt.Errorf("unknown op: %s", op.Op)
+		}
+
+		if e != nil {
+			return nil, ApiError{http.StatusBadRequest, fmt.Errorf("operation %d: %v", i, e)}
+		}
+	}
+
+	return doc, nil
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// Violation - нарушение схемы, Pointer указывает на поле в теле запроса (RFC 6901)
+type Violation struct {
+	Pointer string `json:"pointer"`
+	Error   string `json:"error"`
+}
+
+// Violations - нарушения схемы как ошибка, чтобы вернуть их из глубины обработчика, например из writeRecord
+type Violations []Violation
+
+func (Violations) Error() string {
+	return "invalid record"
+}
+
+// режимы проверки записи: в каждом свои обязательные колонки
+type validationMode int
+
+const (
+	validateCreate  validationMode = iota // createRequired и required из переопределений
+	validateUpdate                        // обязательных нет: отсутствующие колонки не меняются
+	validateReplace                       // replaceRequired и required из переопределений, кроме primary key - он в пути
+)
+
+// tableJsonSchema - JSON Schema записи для вставки: все колонки и обязательные по createRequired
+func (explorer *DbExplorer) tableJsonSchema(tableName string) map[string]Any {
+	columns := explorer.columnTypes[tableName]
+
+	schema := objectSchema(columns, func(ColumnInfo) bool { return false }, createRequired(columns))
+	schema["$schema"] = jsonSchemaDialect
+	schema["$id"] = "/" + tableName + "/_schema.json"
+	schema["title"] = tableName
+
+	return schema
+}
+
+// readSchemaOverrides читает файл SchemaOverridesFile: {"users": {"properties": {"email": {"format": "email"}}}}.
+// Схема таблицы из файла накладывается на сгенерированную как JSON Merge Patch
+func readSchemaOverrides(path string) (map[string]Any, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+
+	body, re := ioutil.ReadFile(path)
+	if re != nil {
+		return nil, re
+	}
+
+	var overrides map[string]Any
+	if de := decodeJsonBody(body, &overrides); de != nil {
+		return nil, fmt.Errorf("schema overrides: %v", de)
+	}
+
+	return overrides, nil
+}
+
+// buildJsonSchemas собирает схемы всех таблиц с учётом переопределений
+func (explorer *DbExplorer) buildJsonSchemas(overrides map[string]Any) (map[string]map[string]Any, error) {
+	for t := range overrides {
+		if _, ok := explorer.columnTypes[t]; !ok {
+			return nil, fmt.Errorf("schema overrides: unknown table %s", t)
+		}
+	}
+
+	schemas := make(map[string]map[string]Any, len(explorer.columnTypes))
+	for t := range explorer.columnTypes {
+		// через json, чтобы и сгенерированная часть, и файл состояли из одних и тех же типов
+		var schema Any = deepCopy(explorer.tableJsonSchema(t))
+		if override, ok := overrides[t]; ok {
+			schema = mergePatch(schema, deepCopy(override))
+		}
+
+		object, ok := schema.(map[string]Any)
+		if !ok {
+			return nil, fmt.Errorf("schema overrides: %s must be an object", t)
+		}
+		schemas[t] = object
+	}
+
+	return schemas, nil
+}
+
+// validateRecord проверяет тело записи по схеме таблицы и возвращает все нарушения сразу
+func (explorer *DbExplorer) validateRecord(tableName string, data map[string]Any, mode validationMode, parent *Filter) []Violation {
+	schema := explorer.jsonSchemas[tableName]
+	if mode != validateCreate {
+		withRequired := make(map[string]Any, len(schema))
+		for k, v := range schema {
+			if k != "required" {
+				withRequired[k] = v
+			}
+		}
+
+		if mode == validateReplace {
+			withRequired["required"] = replaceSchemaRequired(schema["required"], explorer.columnTypes[tableName])
+		}
+		schema = withRequired
+	}
+
+	// колонку родителя во вложенном маршруте подставляет сервер
+	if parent != nil {
+		withParent := make(map[string]Any, len(data)+1)
+		for k, v := range data {
+			withParent[k] = v
+		}
+		withParent[parent.Column] = parent.Values[0]
+		data = withParent
+	}
+
+	var violations []Violation
+	validateSchema(schema, data, "", &violations)
+	sort.SliceStable(violations, func(i, j int) bool { return violations[i].Pointer < violations[j].Pointer })
+
+	return violations
+}
+
+func handleViolations(w http.ResponseWriter, violations []Violation) {
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write(ServerError{
+		Error:    "invalid record",
+		Response: map[string]interface{}{"errors": violations},
+	}.Marshal())
+}
+
+// replaceSchemaRequired - required для полной замены: replaceRequired, как в схеме .replace из OpenAPI,
+// и колонки, которые сделали обязательными переопределения, кроме primary key
+func replaceSchemaRequired(required Any, columns []ColumnInfo) []Any {
+	names := replaceRequired(columns)
+	seen := map[string]bool{}
+	for _, name := range names {
+		seen[name] = true
+	}
+
+	extra, _ := required.([]Any)
+	for _, name := range extra {
+		s, _ := name.(string)
+		if c, ok := findColumn(columns, s); seen[s] || ok && c.PrimaryKey {
+			continue
+		}
+
+		seen[s] = true
+		names = append(names, s)
+	}
+
+	return stringsToAny(names)
+}
+
+func escapePointer(token string) string {
+	return strings.Replace(strings.Replace(token, "~", "~0", -1), "/", "~1", -1)
+}
+
+func jsonType(v Any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []Any:
+		return "array"
+	case map[string]Any:
+		return "object"
+	default:
+		if _, ok := toRat(v); ok {
+			return "number"
+		}
+
+		return "unknown"
+	}
+}
+
+func toRat(v Any) (*big.Rat, bool) {
+	s, ok := numberString(v)
+	if !ok {
+		return nil, false
+	}
+
+	return new(big.Rat).SetString(s)
+}
+
+func hasType(v Any, t string) bool {
+	actual := jsonType(v)
+	if t == "integer" {
+		r, ok := toRat(v)
+
+		return ok && r.IsInt()
+	}
+
+	return actual == t
+}
+
+func typeNames(t Any) []string {
+	switch tt := t.(type) {
+	case string:
+		return []string{tt}
+	case []Any:
+		names := make([]string, 0, len(tt))
+		for _, n := range tt {
+			if s, ok := n.(string); ok {
+				names = append(names, s)
+			}
+		}
+
+		return names
+	}
+
+	return nil
+}
+
+var schemaFormats = map[string]func(string) bool{
+	"date": func(s string) bool {
+		_, e := time.Parse(dateLayout, s)
+
+		return e == nil
+	},
+	// те же форматы, что принимает ParseDateTimeValue
+	"date-time": func(s string) bool {
+		for _, layout := range dateTimeInputLayouts {
+			if _, e := time.Parse(layout, s); e == nil {
+				return true
+			}
+		}
+
+		return false
+	},
+	"email": func(s string) bool {
+		a, e := mail.ParseAddress(s)
+
+		return e == nil && a.Address == s
+	},
+	"uri": func(s string) bool {
+		u, e := url.Parse(s)
+
+		return e == nil && u.IsAbs()
+	},
+	"uuid": regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`).MatchString,
+}
+
+// validateSchema проверяет значение по подмножеству JSON Schema 2020-12, которого хватает для схем таблиц:
+// type, enum, const, границы чисел, длины строк, pattern, format, массивы, объекты и allOf/anyOf/oneOf/not.
+// Остальные ключевые слова считаются аннотациями и не проверяются
+func validateSchema(schema map[string]Any, v Any, pointer string, out *[]Violation) {
+	fail := func(format string, args ...Any) {
+		*out = append(*out, Violation{Pointer: pointer, Error: fmt.Sprintf(format, args...)})
+	}
+
+	if t, ok := schema["type"]; ok {
+		names := typeNames(t)
+		matched := false
+		for _, n := range names {
+			if hasType(v, n) {
+				matched = true
+
+				break
+			}
+		}
+
+		if !matched {
+			fail("must be %s", strings.Join(names, " or "))
+
+			return
+		}
+	}
+
+	if enum, ok := schema["enum"].([]Any); ok {
+		found := false
+		for _, e := range enum {
+			if jsonEqual(e, v) {
+				found = true
+
+				break
+			}
+		}
+
+		if !found {
+			values := make([]string, len(enum))
+			for i, e := range enum {
+				b, _ := json.Marshal(e)
+				values[i] = string(b)
+			}
+			fail("must be one of: %s", strings.Join(values, ", "))
+		}
+	}
+
+	if c, ok := schema["const"]; ok && !jsonEqual(c, v) {
+		fail("must be %v", c)
+	}
+
+	if r, ok := toRat(v); ok {
+		bound := func(keyword, message string, failed func(int) bool) {
+			if b, has := toRat(schema[keyword]); has && failed(r.Cmp(b)) {
+				fail(message, b.RatString())
+			}
+		}
+
+		bound("minimum", "must be >= %s", func(c int) bool { return c < 0 })
+		bound("maximum", "must be <= %s", func(c int) bool { return c > 0 })
+		bound("exclusiveMinimum", "must be > %s", func(c int) bool { return c <= 0 })
+		bound("exclusiveMaximum", "must be < %s", func(c int) bool { return c >= 0 })
+
+		if m, has := toRat(schema["multipleOf"]); has && m.Sign() != 0 && !new(big.Rat).Quo(r, m).IsInt() {
+			fail("must be a multiple of %s", m.RatString())
+		}
+	}
+
+	if s, ok := v.(string); ok {
+		length := int64(utf8.RuneCountInString(s))
+
+		if n, has := toRat(schema["minLength"]); has && big.NewRat(length, 1).Cmp(n) < 0 {
+			fail("must be at least %s characters", n.RatString())
+		}
+
+		if n, has := toRat(schema["maxLength"]); has && big.NewRat(length, 1).Cmp(n) > 0 {
+			fail("must be at most %s characters", n.RatString())
+		}
+
+		if p, has := schema["pattern"].(string); has {
+			re, e := regexp.Compile(p)
+			if e != nil {
+				fail("bad pattern in schema: %s", p)
+			} else if !re.MatchString(s) {
+				fail("must match pattern %s", p)
+			}
+		}
+
+		if f, has := schema["format"].(string); has {
+			if check, known := schemaFormats[f]; known && !check(s) {
+				fail("must be a valid %s", f)
+			}
+		}
+
+		if enc, has := schema["contentEncoding"].(string); has && enc == "base64" {
+			if _, e := base64.StdEncoding.DecodeString(s); e != nil {
+				fail("must be base64 encoded")
+			}
+		}
+	}
+
+	if items, ok := v.([]Any); ok {
+		count := big.NewRat(int64(len(items)), 1)
+
+		if n, has := toRat(schema["minItems"]); has && count.Cmp(n) < 0 {
+			fail("must have at least %s items", n.RatString())
+		}
+
+		if n, has := toRat(schema["maxItems"]); has && count.Cmp(n) > 0 {
+			fail("must have at most %s items", n.RatString())
+		}
+
+		if unique, _ := schema["uniqueItems"].(bool); unique && hasDuplicates(items) {
+			fail("items must be unique")
+		}
+
+		if itemSchema, has := schema["items"].(map[string]Any); has {
+			for i, item := range items {
+				validateSchema(itemSchema, item, fmt.Sprintf("%s/%d", pointer, i), out)
+			}
+		}
+	}
+
+	if object, ok := v.(map[string]Any); ok {
+		if required, has := schema["required"].([]Any); has {
+			for _, r := range required {
+				name, _ := r.(string)
+				if _, present := object[name]; !present {
+					*out = append(*out, Violation{Pointer: pointer + "/" + escapePointer(name), Error: "is required"})
+				}
+			}
+		}
+
+		properties, _ := schema["properties"].(map[string]Any)
+		names := make([]string, 0, len(object))
+		for k := range object {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+
+		for _, k := range names {
+			child := pointer + "/" + escapePointer(k)
+
+			if propertySchema, has := properties[k].(map[string]Any); has {
+				validateSchema(propertySchema, object[k], child, out)
+
+				continue
+			}
+
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					*out = append(*out, Violation{Pointer: child, Error: "is not allowed"})
+				}
+			case map[string]Any:
+				validateSchema(additional, object[k], child, out)
+			}
+		}
+	}
+
+	if all, ok := schema["allOf"].([]Any); ok {
+		for _, sub := range all {
+			if subSchema, is := sub.(map[string]Any); is {
+				validateSchema(subSchema, v, pointer, out)
+			}
+		}
+	}
+
+	if anyOf, ok := schema["anyOf"].([]Any); ok && countMatches(anyOf, v) == 0 {
+		fail("must match at least one schema")
+	}
+
+	if oneOf, ok := schema["oneOf"].([]Any); ok && countMatches(oneOf, v) != 1 {
+		fail("must match exactly one schema")
+	}
+
+	if not, ok := schema["not"].(map[string]Any); ok && countMatches([]Any{not}, v) == 1 {
+		fail("must not match schema")
+	}
+}
+
+func hasDuplicates(items []Any) bool {
+	for i := range items {
+		for j := i + 1; j < len(items); j++ {
+			if jsonEqual(items[i], items[j]) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// countMatches считает подсхемы, которым значение соответствует без нарушений
+func countMatches(schemas []Any, v Any) int {
+	matches := 0
+	for _, sub := range schemas {
+		subSchema, ok := sub.(map[string]Any)
+		if !ok {
+			continue
+		}
+
+		var violations []Violation
+		validateSchema(subSchema, v, "", &violations)
+		if len(violations) == 0 {
+			matches++
+		}
+	}
+
+	return matches
+}
+
+//GET /$table/_schema.json - JSON Schema записи таблицы с учётом файла переопределений
+func (explorer *DbExplorer) handleGetTableJsonSchema(w http.ResponseWriter, r *http.Request) {
+	tableName := strings.TrimPrefix(strings.TrimSuffix(r.URL.Path, "/_schema.json"), "/")
+
+	if te := explorer.tableShouldExist(tableName); te != nil {
+		handleServerError(w, http.StatusNotFound, te)
+
+		return
+	}
+
+	b, me := json.Marshal(explorer.jsonSchemas[tableName])
+	panicOnError(me)
+
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Write(b)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestValidateSchema(t *testing.T) {
+	var schema map[string]Any
+	e := decodeJsonBody([]byte(`{
+  "type": "object",
+  "required": ["name", "age"],
+  "additionalProperties": false,
+  "properties": {
+    "name": {"type": "string", "minLength": 2, "maxLength": 5, "pattern": "^[a-z]+$"},
+    "age": {"type": "integer", "minimum": 0, "exclusiveMaximum": 150},
+    "email": {"type": ["string", "null"], "format": "email"},
+    "role": {"enum": ["admin", "user"]},
+    "tags": {"type": "array", "items": {"type": "string"}, "uniqueItems": true, "maxItems": 2},
+    "a/b": {"const": 1}
+  }
+}`), &schema)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	cases := []struct {
+		body string
+		want []Violation
+	}{
+		{`{"name": "bob", "age": 30, "email": null, "role": "admin", "tags": ["a"], "a/b": 1.0}`, nil},
+		{`{"name": "bob", "age": 30, "email": "bob@example.com"}`, nil},
+		{`{}`, []Violation{{"/name", "is required"}, {"/age", "is required"}}},
+		{`{"name": "B", "age": 150}`, []Violation{
+			{"/age", "must be < 150"},
+			{"/name", "must be at least 2 characters"},
+			{"/name", "must match pattern ^[a-z]+$"},
+		}},
+		{`{"name": "bob", "age": 1.5, "email": "not an email", "role": "root"}`, []Violation{
+			{"/age", "must be integer"},
+			{"/email", "must be a valid email"},
+			{"/role", `must be one of: "admin", "user"`},
+		}},
+		{`{"name": "bob", "age": 1, "tags": ["a", "a", 3], "a/b": 2, "extra": true}`, []Violation{
+			{"/a~1b", "must be 1"},
+			{"/extra", "is not allowed"},
+			{"/tags", "must have at most 2 items"},
+			{"/tags", "items must be unique"},
+			{"/tags/2", "must be string"},
+		}},
+	}
+
+	for _, c := range cases {
+		var body Any
+		if e := decodeJsonBody([]byte(c.body), &body); e != nil {
+			t.Fatal(e)
+		}
+
+		var got []Violation
+		validateSchema(schema, body, "", &got)
+
+		if !reflect.DeepEqual(got, c.want) {
+			g, _ := json.Marshal(got)
+			w, _ := json.Marshal(c.want)
+			t.Errorf("%s:\nGot : %s\nWant: %s", c.body, g, w)
+		}
+	}
+}
+
+func TestColumnSchemaBounds(t *testing.T) {
+	cases := []struct {
+		t     string
+		value Any
+		ok    bool
+	}{
+		{"tinyint", json.Number("127"), true},
+		{"tinyint", json.Number("128"), false},
+		{"int unsigned", json.Number("-1"), false},
+		{"bigint unsigned", json.Number("18446744073709551615"), true},
+		{"decimal(5,2)", json.Number("999.99"), true},
+		{"decimal(5,2)", json.Number("1000"), false},
+		{"decimal(5,2)", "12.5", true},
+		{"decimal(5,2)", "abc", false},
+		{"tinyint(1)", json.Number("1"), true},
+		{"tinyint(1)", json.Number("2"), false},
+		{"varchar(3)", "abcd", false},
+		{"year", json.Number("0"), true},
+		{"year", json.Number("1500"), false},
+		{"year", json.Number("2024"), true},
+		{"year", json.Number("2156"), false},
+		{"time", "10:00:00", true},
+		{"time", "10:00", false},
+		{"set('a','b')", []Any{"a", "c"}, false},
+		{"set('a','b')", "a,b", true},
+		{"blob", "!!", false},
+	}
+
+	for _, c := range cases {
+		ct, e := ParseColumnType(c.t)
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		schema, _ := deepCopy(columnSchema(ColumnInfo{Name: "f", Type: c.t, ColumnType: ct})).(map[string]Any)
+		var violations []Violation
+		validateSchema(schema, c.value, "/f", &violations)
+
+		if (len(violations) == 0) != c.ok {
+			t.Errorf("%s %v: ok = %v, violations: %v", c.t, c.value, c.ok, violations)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 
 	"bytes"
@@ -11,9 +12,8 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"time"
-
-	_ "github.com/go-sql-driver/mysql"
 )
 
 // CaseResponse
@@ -26,23 +26,59 @@ type Case struct {
 	Status int
 	Result interface{}
 	Body   interface{}
+	Header map[string]string // ожидаемые заголовки ответа
+
+	RequestHeader map[string]string // заголовки запроса
 }
 
 var (
 	client = &http.Client{Timeout: time.Second}
+
+	// тесты гоняются на sqlite в памяти процесса, поднимать mysql не нужно
+	TestDriver = "sqlite"
+	TestDSN    = ":memory:"
 )
 
+// OpenTestDB открывает тестовую базу
+// база :memory: живёт ровно столько, сколько живёт соединение, поэтому оно должно быть единственным
+func OpenTestDB() *sql.DB {
+	db, err := sql.Open(TestDriver, TestDSN)
+	if err != nil {
+		panic(err)
+	}
+	db.SetMaxOpenConns(1)
+	err = db.Ping()
+	if err != nil {
+		panic(err)
+	}
+
+	return db
+}
+
+func NewTestExplorer(db *sql.DB) http.Handler {
+	dialect, err := DialectByName(TestDriver)
+	if err != nil {
+		panic(err)
+	}
+
+	handler, err := NewDbExplorerWithDialect(db, dialect)
+	if err != nil {
+		panic(err)
+	}
+
+	return handler
+}
+
 func PrepareTestApis(db *sql.DB) {
 	qs := []string{
 		`DROP TABLE IF EXISTS items;`,
 
 		`CREATE TABLE items (
-  id int(11) NOT NULL AUTO_INCREMENT,
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
   title varchar(255) NOT NULL,
   description text NOT NULL,
-  updated varchar(255) DEFAULT NULL,
-  PRIMARY KEY (id)
-) ENGINE=InnoDB DEFAULT CHARSET=utf8;`,
+  updated varchar(255) DEFAULT NULL
+);`,
 
 		`INSERT INTO items (id, title, description, updated) VALUES
 (1,	'database/sql',	'Рассказать про базы данных',	'rvasily'),
@@ -51,14 +87,13 @@ func PrepareTestApis(db *sql.DB) {
 		`DROP TABLE IF EXISTS users;`,
 
 		`CREATE TABLE users (
-			user_id int(11) NOT NULL AUTO_INCREMENT,
+  user_id INTEGER PRIMARY KEY AUTOINCREMENT,
   login varchar(255) NOT NULL,
   password varchar(255) NOT NULL,
   email varchar(255) NOT NULL,
   info text NOT NULL,
-  updated varchar(255) DEFAULT NULL,
-  PRIMARY KEY (user_id)
-) ENGINE=InnoDB DEFAULT CHARSET=utf8;`,
+  updated varchar(255) DEFAULT NULL
+);`,
 
 		`INSERT INTO users (user_id, login, password, email, info, updated) VALUES
 (1,	'rvasily',	'love',	'rvasily@example.com',	'none',	NULL);`,
@@ -86,23 +121,16 @@ func CleanupTestApis(db *sql.DB) {
 }
 
 func TestApis(t *testing.T) {
-	db, err := sql.Open("mysql", DSN)
-	err = db.Ping()
-	if err != nil {
-		panic(err)
-	}
+	db := OpenTestDB()
+	defer db.Close()
 
 	PrepareTestApis(db)
 
 	// возможно вам будет удобно закомментировать это чтобы смотреть результат после теста
 	defer CleanupTestApis(db)
 
-	handler, err := NewDbExplorer(db)
-	if err != nil {
-		panic(err)
-	}
-
-	ts := httptest.NewServer(handler)
+	ts := httptest.NewServer(NewTestExplorer(db))
+	defer ts.Close()
 
 	cases := []Case{
 		Case{ // 1
@@ -325,7 +353,10 @@ func TestApis(t *testing.T) {
 				"title": 42,
 			},
 			Result: CR{
-				"error": "field title have invalid type",
+				"error": "invalid record",
+				"response": CR{
+					"errors": []CR{{"pointer": "/title", "error": "must be string"}},
+				},
 			},
 		},
 		Case{ // 17
@@ -336,7 +367,10 @@ func TestApis(t *testing.T) {
 				"title": nil,
 			},
 			Result: CR{
-				"error": "field title have invalid type",
+				"error": "invalid record",
+				"response": CR{
+					"errors": []CR{{"pointer": "/title", "error": "must be string"}},
+				},
 			},
 		},
 
@@ -348,7 +382,10 @@ func TestApis(t *testing.T) {
 				"updated": 42,
 			},
 			Result: CR{
-				"error": "field updated have invalid type",
+				"error": "invalid record",
+				"response": CR{
+					"errors": []CR{{"pointer": "/updated", "error": "must be string or null"}},
+				},
 			},
 		},
 
@@ -497,56 +534,2152 @@ func TestApis(t *testing.T) {
 				},
 			},
 		},
-	}
 
-	runCases(t, ts, db, cases)
-}
+		// фильтры
+		Case{ // 29
+			Path:  "/items",
+			Query: "title=eq.memcache",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{
+							"id":          2,
+							"title":       "memcache",
+							"description": "Рассказать про мемкеш с примером использования",
+							"updated":     nil,
+						},
+					},
+				},
+			},
+		},
+		Case{ // 30
+			Path:  "/items",
+			Query: "id=gte.1&updated=is.null",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{
+							"id":          2,
+							"title":       "memcache",
+							"description": "Рассказать про мемкеш с примером использования",
+							"updated":     nil,
+						},
+					},
+				},
+			},
+		},
+		Case{ // 31
+			Path:  "/items",
+			Query: "id=in.(1,2)&title=ilike.DATABASE%25",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{
+							"id":          1,
+							"title":       "database/sql",
+							"description": "Рассказать про базы данных",
+							"updated":     "rvasily",
+						},
+					},
+				},
+			},
+		},
+		Case{ // 32
+			Path:  "/users",
+			Query: "login=like.rv%25&updated=is.notnull",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{
+							"user_id":  1,
+							"login":    "rvasily",
+							"password": "love",
+							"email":    "rvasily@example.com",
+							"info":     "try update",
+							"updated":  "now",
+						},
+					},
+				},
+			},
+		},
+		Case{ // 33
+			Path:   "/items",
+			Query:  "unknown=eq.1",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "unknown column: unknown",
+			},
+		},
+		Case{ // 34
+			Path:   "/items",
+			Query:  "id=eq.1'",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "field id have invalid type",
+			},
+		},
+		Case{ // 35
+			Path:   "/items",
+			Query:  "id=between.1",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "unknown filter operator: between",
+			},
+		},
 
-func runCases(t *testing.T, ts *httptest.Server, db *sql.DB, cases []Case) {
-	for idx, item := range cases {
-		var (
-			err      error
-			result   interface{}
-			expected interface{}
-			req      *http.Request
-		)
+		// сортировка
+		Case{ // 36
+			Path:  "/items",
+			Query: "order=updated.asc.nullsfirst",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{
+							"id":          2,
+							"title":       "memcache",
+							"description": "Рассказать про мемкеш с примером использования",
+							"updated":     nil,
+						},
+						CR{
+							"id":          1,
+							"title":       "database/sql",
+							"description": "Рассказать про базы данных",
+							"updated":     "rvasily",
+						},
+					},
+				},
+			},
+		},
+		Case{ // 37
+			Path:  "/items",
+			Query: "order=id.desc&limit=1",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{
+							"id":          2,
+							"title":       "memcache",
+							"description": "Рассказать про мемкеш с примером использования",
+							"updated":     nil,
+						},
+					},
+				},
+			},
+		},
+		Case{ // 38
+			Path:   "/items",
+			Query:  "order=id.sideways",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "bad order: id.sideways",
+			},
+		},
+		Case{ // 39
+			Path:   "/items",
+			Query:  "order=password",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "unknown column: password",
+			},
+		},
 
-		caseName := fmt.Sprintf("case %d: [%s] %s %s", idx, item.Method, item.Path, item.Query)
+		// выбор колонок
+		Case{ // 40
+			Path:  "/items",
+			Query: "select=id,title&order=id.desc",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{
+							"id":    2,
+							"title": "memcache",
+						},
+						CR{
+							"id":    1,
+							"title": "database/sql",
+						},
+					},
+				},
+			},
+		},
+		Case{ // 41
+			Path:  "/users/1",
+			Query: "select=login,email",
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"login": "rvasily",
+						"email": "rvasily@example.com",
+					},
+				},
+			},
+		},
+		Case{ // 42
+			Path:   "/users/1",
+			Query:  "select=login,secret",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "unknown column: secret",
+			},
+		},
 
-		// если у вас случилась это ошибка - значит вы не делаете где-то rows.Close и у вас текут соединения с базой
-		// если такое случилось на первом тесте - значит вы не закрываете коннект где-то при иницаилизации в NewDbExplorer
-		if db.Stats().OpenConnections != 1 {
-			t.Fatalf("[%s] you have %d open connections, must be 1", caseName, db.Stats().OpenConnections)
-		}
+		// общее число записей
+		Case{ // 43
+			Path:   "/items",
+			Query:  "count=exact&limit=1&select=id",
+			Header: map[string]string{"Content-Range": "records 0-0/2"},
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{
+							"id": 1,
+						},
+					},
+					"total":    2,
+					"limit":    1,
+					"offset":   0,
+					"has_more": true,
+				},
+			},
+		},
+		Case{ // 44
+			Path:   "/items",
+			Query:  "count=estimated&title=eq.memcache&select=id",
+			Header: map[string]string{"Content-Range": "records 0-0/1"},
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{
+							"id": 2,
+						},
+					},
+					"total":    1,
+					"limit":    1000,
+					"offset":   0,
+					"has_more": false,
+				},
+			},
+		},
+		Case{ // 45
+			Path:   "/items",
+			Query:  "title=eq.nothing",
+			Header: map[string]string{"Content-Range": "records */*"},
+			Result: CR{
+				"response": CR{
+					"records": []CR{},
+				},
+			},
+		},
+		Case{ // 46
+			Path:   "/items",
+			Query:  "count=some",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "bad count: some",
+			},
+		},
+	}
 
-		if item.Method == "" || item.Method == http.MethodGet {
-			req, err = http.NewRequest(item.Method, ts.URL+item.Path+"?"+item.Query, nil)
-		} else {
-			data, err := json.Marshal(item.Body)
-			if err != nil {
-				panic(err)
-			}
-			reqBody := bytes.NewReader(data)
-			req, err = http.NewRequest(item.Method, ts.URL+item.Path, reqBody)
-			req.Header.Add("Content-Type", "application/json")
-		}
+	runCases(t, ts, db, cases)
+}
 
-		resp, err := client.Do(req)
-		if err != nil {
-			t.Fatalf("[%s] request error: %v", caseName, err)
-			continue
-		}
-		defer resp.Body.Close()
-		body, err := ioutil.ReadAll(resp.Body)
+func TestTypes(t *testing.T) {
+	db := OpenTestDB()
+	defer db.Close()
 
-		// fmt.Printf("[%s] body: %s\n", caseName, string(body))
-		if item.Status == 0 {
-			item.Status = http.StatusOK
-		}
+	_, err := db.Exec(`CREATE TABLE typed (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  flag tinyint(1) NOT NULL,
+  price decimal(10,2) NOT NULL,
+  created datetime DEFAULT NULL,
+  payload json DEFAULT NULL,
+  data blob DEFAULT NULL,
+  note text DEFAULT NULL,
+  y year DEFAULT NULL
+);`)
+	if err != nil {
+		panic(err)
+	}
 
-		if resp.StatusCode != item.Status {
-			t.Fatalf("[%s] expected http status %v, got %v", caseName, item.Status, resp.StatusCode)
-			continue
+	ts := httptest.NewServer(NewTestExplorer(db))
+	defer ts.Close()
+
+	cases := []Case{
+		Case{
+			Path:   "/typed/",
+			Method: http.MethodPut,
+			Body: CR{
+				"flag":    true,
+				"price":   12.5,
+				"created": "2020-01-02T03:04:05+03:00",
+				"payload": CR{"tags": []string{"a", "b"}},
+				"data":    "aGk=",
+			},
+			Result: CR{
+				"response": CR{
+					"id": 1,
+				},
+			},
+		},
+		Case{
+			Path: "/typed/1",
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"id":      1,
+						"flag":    true,
+						"price":   12.5,
+						"created": "2020-01-02T00:04:05Z",
+						"payload": CR{"tags": []string{"a", "b"}},
+						"data":    "aGk=",
+						"note":    nil,
+						"y":       nil,
+					},
+				},
+			},
+		},
+		Case{
+			Path:   "/typed/1",
+			Method: http.MethodPost,
+			Status: http.StatusBadRequest,
+			Body: CR{
+				"price": 123456789.5,
+			},
+			Result: CR{
+				"error": "invalid record",
+				"response": CR{
+					"errors": []CR{{"pointer": "/price", "error": "must be < 100000000"}},
+				},
+			},
+		},
+		// лимит 64KB на text есть только в mysql
+		Case{
+			Path:   "/typed/",
+			Method: http.MethodPut,
+			Body:   CR{"flag": false, "price": 1, "note": fmt.Sprintf("%070000d", 0)},
+			Result: CR{"response": CR{"id": 2}},
+		},
+		Case{
+			Path:   "/typed/",
+			Method: http.MethodPut,
+			Body:   CR{"flag": false, "price": 1, "y": 1500},
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error":    "invalid record",
+				"response": CR{"errors": []CR{{"pointer": "/y", "error": "must match at least one schema"}}},
+			},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+
+	// в tinyint(1) может лежать любое число, истиной считается всё, кроме нуля
+	if _, err := db.Exec(`INSERT INTO typed (id, flag, price) VALUES (3, 2, 1)`); err != nil {
+		panic(err)
+	}
+
+	runCases(t, ts, db, []Case{
+		Case{
+			Path:   "/typed",
+			Query:  "select=id,flag",
+			Result: CR{"response": CR{"records": []CR{{"id": 1, "flag": true}, {"id": 2, "flag": false}, {"id": 3, "flag": true}}}},
+		},
+		Case{
+			Path:   "/typed/3",
+			Query:  "select=flag",
+			Result: CR{"response": CR{"record": CR{"flag": true}}},
+		},
+	})
+}
+
+func TestPrimaryKeys(t *testing.T) {
+	db := OpenTestDB()
+	defer db.Close()
+
+	_, err := db.Exec(`CREATE TABLE tags (slug varchar(64) PRIMARY KEY, title text NOT NULL);
+CREATE TABLE item_tags (item_id int NOT NULL, tag varchar(64) NOT NULL, note text, PRIMARY KEY (item_id, tag));`)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(NewTestExplorer(db))
+	defer ts.Close()
+
+	cases := []Case{
+		Case{
+			Path:   "/tags/",
+			Method: http.MethodPut,
+			Body:   CR{"slug": "go-lang", "title": "Go"},
+			Result: CR{"response": CR{"slug": "go-lang"}},
+		},
+		Case{
+			Path:   "/tags/go-lang",
+			Result: CR{"response": CR{"record": CR{"slug": "go-lang", "title": "Go"}}},
+		},
+		// при полной замене ключ берётся из пути, в теле он не обязателен
+		Case{
+			Path:   "/tags/go-lang",
+			Method: http.MethodPut,
+			Body:   CR{"title": "Golang"},
+			Result: CR{"response": CR{"updated": 1}},
+		},
+		Case{
+			Path:   "/tags/go-lang",
+			Result: CR{"response": CR{"record": CR{"slug": "go-lang", "title": "Golang"}}},
+		},
+		Case{
+			Path:   "/item_tags/",
+			Method: http.MethodPut,
+			Body:   CR{"item_id": 1, "tag": "go"},
+			Result: CR{"response": CR{"item_id": 1, "tag": "go"}},
+		},
+		Case{
+			Path:   "/item_tags/1,go",
+			Result: CR{"response": CR{"record": CR{"item_id": 1, "tag": "go", "note": nil}}},
+		},
+		Case{
+			Path:   "/item_tags/1,go",
+			Method: http.MethodPost,
+			Body:   CR{"note": "first"},
+			Result: CR{"response": CR{"updated": 1}},
+		},
+		Case{
+			Path:   "/item_tags/tag=go;item_id=1",
+			Result: CR{"response": CR{"record": CR{"item_id": 1, "tag": "go", "note": "first"}}},
+		},
+		Case{
+			Path:   "/item_tags/1",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "expected 2 key values"},
+		},
+		Case{
+			Path:   "/item_tags/x,go",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "field item_id have invalid type"},
+		},
+		Case{
+			Path:   "/item_tags/item_id=1;slug=go",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "unknown key column: slug"},
+		},
+		// "=" в значении ключа - не матричные параметры
+		Case{
+			Path:   "/tags/",
+			Method: http.MethodPut,
+			Body:   CR{"slug": "YWJj=", "title": "base64"},
+			Result: CR{"response": CR{"slug": "YWJj="}},
+		},
+		Case{
+			Path:   "/tags/YWJj=",
+			Query:  "select=title",
+			Result: CR{"response": CR{"record": CR{"title": "base64"}}},
+		},
+		Case{
+			Path:   "/item_tags/",
+			Method: http.MethodPut,
+			Body:   CR{"item_id": 2, "tag": "a=b"},
+			Result: CR{"response": CR{"item_id": 2, "tag": "a=b"}},
+		},
+		Case{
+			Path:   "/item_tags/2,a=b",
+			Query:  "select=tag",
+			Result: CR{"response": CR{"record": CR{"tag": "a=b"}}},
+		},
+		Case{
+			Path:   "/item_tags/1,go",
+			Method: http.MethodDelete,
+			Result: CR{"response": CR{"deleted": 1}},
+		},
+		Case{
+			Path:   "/item_tags/1,go",
+			Status: http.StatusNotFound,
+			Result: CR{"error": "record not found"},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+}
+
+// PrepareBlogTables создаёт таблицы, связанные внешними ключами: authors <- posts <- comments
+func PrepareBlogTables(db *sql.DB) {
+	_, err := db.Exec(`CREATE TABLE authors (id INTEGER PRIMARY KEY, name text NOT NULL);
+CREATE TABLE posts (id INTEGER PRIMARY KEY, title text NOT NULL, author_id int REFERENCES authors (id));
+CREATE TABLE comments (id INTEGER PRIMARY KEY, post_id int NOT NULL REFERENCES posts, body text NOT NULL);
+INSERT INTO authors (id, name) VALUES (1, 'rvasily'), (2, 'nobody');
+INSERT INTO posts (id, title, author_id) VALUES (1, 'sql', 1), (2, 'memcache', 1), (3, 'draft', NULL);
+INSERT INTO comments (id, post_id, body) VALUES (1, 1, 'first'), (2, 1, 'second'), (3, 2, 'third');`)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestEmbed(t *testing.T) {
+	db := OpenTestDB()
+	defer db.Close()
+
+	PrepareBlogTables(db)
+
+	ts := httptest.NewServer(NewTestExplorer(db))
+	defer ts.Close()
+
+	author := CR{"id": 1, "name": "rvasily"}
+	cases := []Case{
+		Case{
+			Path:  "/posts",
+			Query: "embed=author,comments&select=title",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						{"title": "sql", "author": author, "comments": []CR{
+							{"id": 1, "post_id": 1, "body": "first"},
+							{"id": 2, "post_id": 1, "body": "second"},
+						}},
+						{"title": "memcache", "author": author, "comments": []CR{
+							{"id": 3, "post_id": 2, "body": "third"},
+						}},
+						{"title": "draft", "author": nil, "comments": []CR{}},
+					},
+				},
+			},
+		},
+		Case{
+			Path:  "/authors/2",
+			Query: "embed=posts",
+			Result: CR{
+				"response": CR{
+					"record": CR{"id": 2, "name": "nobody", "posts": []CR{}},
+				},
+			},
+		},
+		Case{
+			Path:   "/posts",
+			Query:  "embed=tags",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "unknown relation: tags"},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+}
+
+func TestEmbedNames(t *testing.T) {
+	db := OpenTestDB()
+	defer db.Close()
+
+	_, err := db.Exec(`CREATE TABLE people (id INTEGER PRIMARY KEY, name text NOT NULL, tags int);
+CREATE TABLE docs (id INTEGER PRIMARY KEY, title text NOT NULL, author_id int REFERENCES people (id), editor_id int REFERENCES people (id));
+CREATE TABLE tags (id INTEGER PRIMARY KEY, person_id int REFERENCES people (id));
+INSERT INTO people (id, name, tags) VALUES (1, 'ann', 0), (2, 'bob', 0);
+INSERT INTO docs (id, title, author_id, editor_id) VALUES (1, 'spec', 1, 2);`)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(NewTestExplorer(db))
+	defer ts.Close()
+
+	cases := []Case{
+		// две ссылки на одну таблицу - две разные связи, названные по колонкам
+		Case{
+			Path:  "/docs/1",
+			Query: "select=title&embed=author,editor",
+			Result: CR{"response": CR{"record": CR{
+				"title":  "spec",
+				"author": CR{"id": 1, "name": "ann", "tags": 0},
+				"editor": CR{"id": 2, "name": "bob", "tags": 0},
+			}}},
+		},
+		Case{
+			Path:  "/people/2",
+			Query: "select=name&embed=docs_by_author,docs_by_editor",
+			Result: CR{"response": CR{"record": CR{
+				"name":           "bob",
+				"docs_by_author": []CR{},
+				"docs_by_editor": []CR{{"id": 1, "title": "spec", "author_id": 1, "editor_id": 2}},
+			}}},
+		},
+		Case{
+			Path:   "/people/1/docs",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "ambiguous relation: docs references people by author_id, editor_id"},
+		},
+		// вложенные записи затёрли бы колонку people.tags
+		Case{
+			Path:   "/people/1",
+			Query:  "embed=tags",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "relation tags has the same name as a column"},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+}
+
+func TestNestedRoutes(t *testing.T) {
+	db := OpenTestDB()
+	defer db.Close()
+
+	PrepareBlogTables(db)
+
+	ts := httptest.NewServer(NewTestExplorer(db))
+	defer ts.Close()
+
+	cases := []Case{
+		Case{
+			Path:  "/posts/1/comments",
+			Query: "order=id.desc&select=body",
+			Result: CR{
+				"response": CR{
+					"records": []CR{{"body": "second"}, {"body": "first"}},
+				},
+			},
+		},
+		Case{
+			Path:  "/authors/1/posts",
+			Query: "title=eq.memcache&limit=1",
+			Result: CR{
+				"response": CR{
+					"records": []CR{{"id": 2, "title": "memcache", "author_id": 1}},
+				},
+			},
+		},
+		Case{
+			Path:   "/posts/3/comments",
+			Method: http.MethodPut,
+			Body:   CR{"body": "late", "post_id": 1},
+			Result: CR{"response": CR{"id": 4}},
+		},
+		Case{
+			Path: "/comments/4",
+			Result: CR{
+				"response": CR{
+					"record": CR{"id": 4, "post_id": 3, "body": "late"},
+				},
+			},
+		},
+		Case{
+			Path:   "/posts/42/comments",
+			Status: http.StatusNotFound,
+			Result: CR{"error": "record not found"},
+		},
+		Case{
+			Path:   "/posts/x/comments",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "field id have invalid type"},
+		},
+		Case{
+			Path:   "/comments/1/posts",
+			Status: http.StatusNotFound,
+			Result: CR{"error": "unknown relation: posts"},
+		},
+		Case{
+			Path:   "/blogs/1/posts",
+			Method: http.MethodPut,
+			Body:   CR{"title": "x"},
+			Status: http.StatusNotFound,
+			Result: CR{"error": "unknown table"},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+}
+
+func TestBulkInsert(t *testing.T) {
+	db := OpenTestDB()
+	defer db.Close()
+
+	PrepareTestApis(db)
+
+	ts := httptest.NewServer(NewTestExplorer(db))
+	defer ts.Close()
+
+	cases := []Case{
+		Case{
+			Path:   "/items/",
+			Method: http.MethodPut,
+			Body: []CR{
+				{"title": "a", "description": ""},
+				{"title": "b", "description": ""},
+				{"title": "c", "description": "", "updated": "rvasily"},
+			},
+			Result: CR{
+				"response": CR{
+					"inserted": 3,
+					"ids":      []CR{{"id": 3}, {"id": 4}, {"id": 5}},
+				},
+			},
+		},
+		Case{
+			Path:   "/items/",
+			Method: http.MethodPut,
+			Body:   []interface{}{CR{"title": "d", "description": ""}, CR{"title": 1}, 5},
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "invalid records",
+				"response": CR{
+					"errors": []CR{
+						{"index": 1, "pointer": "/description", "error": "is required"},
+						{"index": 1, "pointer": "/title", "error": "must be string"},
+						{"index": 2, "error": "record must be an object"},
+					},
+				},
+			},
+		},
+		Case{
+			Path:  "/items",
+			Query: "select=title&offset=4&limit=5&count=exact",
+			Result: CR{
+				"response": CR{"records": []CR{{"title": "c"}}, "total": 5, "limit": 5, "offset": 4, "has_more": false},
+			},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+
+	req, _ := http.NewRequest(http.MethodPut, ts.URL+"/items/", bytes.NewBufferString(
+		"{\"title\": \"e\", \"description\": \"\"}\n{\"title\": \"f\", \"description\": \"\"}\n"))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var ndjson CR
+	json.NewDecoder(resp.Body).Decode(&ndjson)
+	if ids := ndjson["response"].(map[string]interface{})["ids"]; !reflect.DeepEqual(ids, []interface{}{map[string]interface{}{"id": 6.0}, map[string]interface{}{"id": 7.0}}) {
+		t.Fatalf("ndjson: %v", ndjson)
+	}
+
+	// больше записей, чем влезает в один insert
+	many := make([]CR, 250)
+	for i := range many {
+		many[i] = CR{"title": fmt.Sprintf("bulk %d", i), "description": ""}
+	}
+	data, _ := json.Marshal(many)
+	req, _ = http.NewRequest(http.MethodPut, ts.URL+"/items/", bytes.NewReader(data))
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var bulk CR
+	json.NewDecoder(resp.Body).Decode(&bulk)
+	ids := bulk["response"].(map[string]interface{})["ids"].([]interface{})
+	if len(ids) != 250 || ids[0].(map[string]interface{})["id"] != 8.0 || ids[249].(map[string]interface{})["id"] != 257.0 {
+		t.Fatalf("chunked insert: %v", bulk["response"].(map[string]interface{})["inserted"])
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	db := OpenTestDB()
+	defer db.Close()
+
+	_, err := db.Exec(`CREATE TABLE accounts (id INTEGER PRIMARY KEY, login varchar(64) NOT NULL UNIQUE, name text NOT NULL)`)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(NewTestExplorer(db))
+	defer ts.Close()
+
+	merge := map[string]string{"Prefer": "resolution=merge-duplicates"}
+	cases := []Case{
+		Case{
+			Path:   "/accounts/?on_conflict=login",
+			Method: http.MethodPut,
+			Body:   CR{"login": "rvasily", "name": "Vasily"},
+			Result: CR{"response": CR{"id": 1}},
+		},
+		Case{
+			Path:   "/accounts/?on_conflict=login",
+			Method: http.MethodPut,
+			Body:   CR{"login": "rvasily", "name": "Vasily R."},
+			Result: CR{"response": CR{"id": 1}},
+		},
+		Case{
+			Path:   "/accounts/1",
+			Result: CR{"response": CR{"record": CR{"id": 1, "login": "rvasily", "name": "Vasily R."}}},
+		},
+		Case{
+			Path:          "/accounts/",
+			Method:        http.MethodPut,
+			RequestHeader: merge,
+			Body:          CR{"id": 1, "login": "vasily", "name": "V"},
+			Result:        CR{"response": CR{"id": 1}},
+		},
+		Case{
+			Path:   "/accounts/1",
+			Query:  "select=login",
+			Result: CR{"response": CR{"record": CR{"login": "vasily"}}},
+		},
+		Case{
+			Path:   "/accounts/?on_conflict=login",
+			Method: http.MethodPut,
+			Body: []CR{
+				{"login": "vasily", "name": "Vasily"},
+				{"login": "admin", "name": "Admin"},
+			},
+			Result: CR{"response": CR{"inserted": 2, "ids": []CR{{"id": 1}, {"id": 2}}}},
+		},
+		// один ключ дважды в одной пачке: побеждает последняя запись, ключ получают обе
+		Case{
+			Path:   "/accounts/?on_conflict=login",
+			Method: http.MethodPut,
+			Body: []CR{
+				{"login": "admin", "name": "A1"},
+				{"login": "guest", "name": "Guest"},
+				{"login": "admin", "name": "A2"},
+			},
+			Result: CR{"response": CR{"inserted": 3, "ids": []CR{{"id": 2}, {"id": 3}, {"id": 2}}}},
+		},
+		Case{
+			Path:   "/accounts",
+			Query:  "select=login,name",
+			Result: CR{"response": CR{"records": []CR{{"login": "vasily", "name": "Vasily"}, {"login": "admin", "name": "A2"}, {"login": "guest", "name": "Guest"}}}},
+		},
+		Case{
+			Path:          "/accounts/",
+			Method:        http.MethodPut,
+			RequestHeader: merge,
+			Body:          CR{"login": "x", "name": "X"},
+			Status:        http.StatusBadRequest,
+			Result:        CR{"error": "id is missing"},
+		},
+		Case{
+			Path:   "/accounts/?on_conflict=name",
+			Method: http.MethodPut,
+			Body:   CR{"login": "x", "name": "X"},
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "on_conflict must match a unique key: name"},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+}
+
+func TestBatchKeys(t *testing.T) {
+	db := OpenTestDB()
+	defer db.Close()
+
+	_, err := db.Exec(`CREATE TABLE item_tags (item_id int NOT NULL, tag varchar(64) NOT NULL, note text, PRIMARY KEY (item_id, tag));
+INSERT INTO item_tags (item_id, tag) VALUES (1, 'go'), (1, 'a,b');`)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(NewTestExplorer(db))
+	defer ts.Close()
+
+	cases := []Case{
+		// составной ключ массивом и объектом, запятая в значении не режется
+		Case{
+			Path:   "/_batch",
+			Method: http.MethodPost,
+			Body: CR{"operations": []CR{
+				{"op": "update", "table": "item_tags", "id": []Any{1, "go"}, "body": CR{"note": "array"}},
+				{"op": "update", "table": "item_tags", "id": CR{"item_id": 1, "tag": "a,b"}, "body": CR{"note": "object"}},
+				{"op": "update", "table": "item_tags", "id": "1,go", "body": CR{"note": "path"}},
+			}},
+			Result: CR{"response": CR{"results": []CR{{"updated": 1}, {"updated": 1}, {"updated": 1}}}},
+		},
+		Case{
+			Path:   "/item_tags/item_id=1;tag=a,b",
+			Query:  "select=note",
+			Result: CR{"response": CR{"record": CR{"note": "object"}}},
+		},
+		Case{
+			Path:   "/_batch",
+			Method: http.MethodPost,
+			Body: CR{"operations": []CR{
+				{"op": "delete", "table": "item_tags", "id": []Any{1}},
+			}},
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "operation 0: expected 2 key values", "response": CR{"index": 0}},
+		},
+		// нарушение ограничения в базе - не ошибка клиента в разборе запроса
+		Case{
+			Path:   "/_batch",
+			Method: http.MethodPost,
+			Body: CR{"operations": []CR{
+				{"op": "create", "table": "item_tags", "body": CR{"item_id": 1, "tag": "go"}},
+			}},
+			Status: http.StatusInternalServerError,
+			Result: CR{"error": "operation 0: constraint failed: UNIQUE constraint failed: item_tags.item_id, item_tags.tag (1555)", "response": CR{"index": 0}},
+		},
+		// upsert по составному ключу: ключи записей находятся одним запросом
+		Case{
+			Path:          "/item_tags/",
+			Method:        http.MethodPut,
+			RequestHeader: map[string]string{"Prefer": "resolution=merge-duplicates"},
+			Body: []CR{
+				{"item_id": 1, "tag": "go", "note": "first"},
+				{"item_id": 2, "tag": "go", "note": "new"},
+				{"item_id": 1, "tag": "go", "note": "last"},
+			},
+			Result: CR{"response": CR{"inserted": 3, "ids": []CR{
+				{"item_id": 1, "tag": "go"}, {"item_id": 2, "tag": "go"}, {"item_id": 1, "tag": "go"},
+			}}},
+		},
+		Case{
+			Path:   "/item_tags/item_id=1;tag=go",
+			Query:  "select=note",
+			Result: CR{"response": CR{"record": CR{"note": "last"}}},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+}
+
+func TestBatch(t *testing.T) {
+	db := OpenTestDB()
+	defer db.Close()
+
+	PrepareBlogTables(db)
+
+	ts := httptest.NewServer(NewTestExplorer(db))
+	defer ts.Close()
+
+	cases := []Case{
+		Case{
+			Path:   "/_batch",
+			Method: http.MethodPost,
+			Body: CR{"operations": []CR{
+				{"op": "create", "table": "authors", "body": CR{"name": "romanov"}},
+				{"op": "create", "table": "posts", "body": CR{"title": "batch", "author_id": CR{"$ref": "0.id"}}},
+				{"op": "update", "table": "posts", "id": CR{"$ref": "1.id"}, "body": CR{"title": "batch api"}},
+				{"op": "delete", "table": "comments", "id": 3},
+			}},
+			Result: CR{
+				"response": CR{
+					"results": []CR{{"id": 3}, {"id": 4}, {"updated": 1}, {"deleted": 1}},
+				},
+			},
+		},
+		Case{
+			Path:   "/authors/3/posts",
+			Result: CR{"response": CR{"records": []CR{{"id": 4, "title": "batch api", "author_id": 3}}}},
+		},
+		Case{
+			Path:   "/_batch",
+			Method: http.MethodPost,
+			Body: CR{"operations": []CR{
+				{"op": "delete", "table": "comments", "id": 1},
+				{"op": "create", "table": "authors", "body": CR{"name": "nobody"}},
+				{"op": "update", "table": "posts", "id": CR{"$ref": "1.id"}, "body": CR{"title": 42}},
+			}},
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error":    "operation 2: invalid record",
+				"response": CR{"index": 2, "errors": []CR{{"pointer": "/title", "error": "must be string"}}},
+			},
+		},
+		Case{
+			Path:   "/_batch",
+			Method: http.MethodPost,
+			Body: CR{"operations": []CR{
+				{"op": "update", "table": "posts", "id": CR{"$ref": "0.id"}, "body": CR{"title": "x"}},
+			}},
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error":    "operation 0: bad reference: 0.id",
+				"response": CR{"index": 0},
+			},
+		},
+		Case{
+			Path:   "/_batch",
+			Method: http.MethodPost,
+			Body: CR{"operations": []CR{
+				{"op": "create", "table": "tags", "body": CR{}},
+			}},
+			Status: http.StatusNotFound,
+			Result: CR{
+				"error":    "operation 0: unknown table",
+				"response": CR{"index": 0},
+			},
+		},
+		Case{
+			Path:  "/comments",
+			Query: "select=id",
+			Result: CR{
+				"response": CR{"records": []CR{{"id": 1}, {"id": 2}}},
+			},
+		},
+		Case{
+			Path:  "/authors",
+			Query: "select=id&count=exact",
+			Result: CR{
+				"response": CR{"records": []CR{{"id": 1}, {"id": 2}, {"id": 3}}, "total": 3, "limit": 1000, "offset": 0, "has_more": false},
+			},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+}
+
+func TestWriteByFilter(t *testing.T) {
+	db := OpenTestDB()
+	defer db.Close()
+
+	PrepareBlogTables(db)
+
+	ts := httptest.NewServer(NewTestExplorer(db))
+	defer ts.Close()
+
+	defer func(limit int64) { MaxAffectedRows = limit }(MaxAffectedRows)
+	MaxAffectedRows = 2
+
+	// ошибка базы при записи - это 500, а не ошибка запроса
+	if _, e := db.Exec(`CREATE TRIGGER authors_locked BEFORE DELETE ON authors BEGIN SELECT RAISE(ABORT, 'authors are locked'); END`); e != nil {
+		t.Fatal(e)
+	}
+
+	cases := []Case{
+		Case{
+			Path:   "/authors?id=eq.2",
+			Method: http.MethodDelete,
+			Status: http.StatusInternalServerError,
+			Result: CR{"error": "constraint failed: authors are locked (1811)"},
+		},
+		Case{
+			Path:   "/posts",
+			Method: http.MethodPatch,
+			Body:   CR{"title": "x"},
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "filter is required"},
+		},
+		Case{
+			Path:   "/posts?author_id=eq.1&dry_run=1",
+			Method: http.MethodPatch,
+			Body:   CR{"title": "archived"},
+			Result: CR{"response": CR{"updated": 2, "dry_run": true}},
+		},
+		Case{
+			Path:   "/posts?author_id=eq.1",
+			Method: http.MethodPatch,
+			Body:   CR{"title": "archived"},
+			Result: CR{"response": CR{"updated": 2}},
+		},
+		Case{
+			Path:   "/posts?id=gt.0",
+			Method: http.MethodPatch,
+			Body:   CR{"title": "all"},
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "too many rows affected: 3, limit is 2"},
+		},
+		Case{
+			Path:  "/posts",
+			Query: "select=title",
+			Result: CR{
+				"response": CR{"records": []CR{{"title": "archived"}, {"title": "archived"}, {"title": "draft"}}},
+			},
+		},
+		Case{
+			Path:   "/posts?id=eq.1",
+			Method: http.MethodPatch,
+			Body:   CR{"id": 5},
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "field id have invalid type"},
+		},
+		Case{
+			Path:   "/comments",
+			Method: http.MethodDelete,
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "filter is required"},
+		},
+		Case{
+			Path:   "/posts/1/comments?dry_run=1",
+			Method: http.MethodDelete,
+			Result: CR{"response": CR{"deleted": 2, "dry_run": true}},
+		},
+		// во вложенной коллекции фильтр - сам родитель: меняются все его дети, фильтры в запросе сужают выборку
+		Case{
+			Path:   "/posts/1/comments",
+			Method: http.MethodPatch,
+			Body:   CR{"body": "hidden"},
+			Result: CR{"response": CR{"updated": 2}},
+		},
+		Case{
+			Path:   "/posts/1/comments?id=eq.2",
+			Method: http.MethodPatch,
+			Body:   CR{"body": "shown"},
+			Result: CR{"response": CR{"updated": 1}},
+		},
+		Case{
+			Path:   "/comments",
+			Query:  "select=body",
+			Result: CR{"response": CR{"records": []CR{{"body": "hidden"}, {"body": "shown"}, {"body": "third"}}}},
+		},
+		Case{
+			Path:   "/comments?id=in.(1,2,3)",
+			Method: http.MethodDelete,
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "too many rows affected: 3, limit is 2"},
+		},
+		Case{
+			Path:   "/posts/1/comments",
+			Method: http.MethodDelete,
+			Result: CR{"response": CR{"deleted": 2}},
+		},
+		Case{
+			Path:   "/comments",
+			Query:  "select=id",
+			Result: CR{"response": CR{"records": []CR{{"id": 3}}}},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+}
+
+func TestReturnRepresentation(t *testing.T) {
+	db := OpenTestDB()
+	defer db.Close()
+
+	_, err := db.Exec(`CREATE TABLE pages (
+  id INTEGER PRIMARY KEY,
+  title text NOT NULL,
+  status varchar(16) DEFAULT 'draft',
+  revision int DEFAULT 1
+);
+CREATE TRIGGER pages_revision AFTER UPDATE OF title ON pages
+BEGIN
+  UPDATE pages SET revision = revision + 1 WHERE id = NEW.id;
+END;`)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(NewTestExplorer(db))
+	defer ts.Close()
+
+	representation := map[string]string{"Prefer": "return=representation"}
+	cases := []Case{
+		Case{
+			Path:          "/pages/",
+			Method:        http.MethodPut,
+			RequestHeader: representation,
+			Body:          CR{"title": "home"},
+			Header:        map[string]string{"Preference-Applied": "return=representation"},
+			Result: CR{
+				"response": CR{
+					"id":     1,
+					"record": CR{"id": 1, "title": "home", "status": "draft", "revision": 1},
+				},
+			},
+		},
+		Case{
+			Path:          "/pages/1",
+			Method:        http.MethodPost,
+			RequestHeader: representation,
+			Body:          CR{"title": "index"},
+			Result: CR{
+				"response": CR{
+					"updated": 1,
+					"record":  CR{"id": 1, "title": "index", "status": "draft", "revision": 2},
+				},
+			},
+		},
+		Case{
+			Path:          "/pages/",
+			Method:        http.MethodPut,
+			RequestHeader: representation,
+			Body:          []CR{{"title": "about", "status": "published"}, {"title": "contacts"}},
+			Result: CR{
+				"response": CR{
+					"inserted": 2,
+					"ids":      []CR{{"id": 2}, {"id": 3}},
+					"records": []CR{
+						{"id": 2, "title": "about", "status": "published", "revision": 1},
+						{"id": 3, "title": "contacts", "status": "draft", "revision": 1},
+					},
+				},
+			},
+		},
+		Case{
+			Path:   "/pages/1",
+			Method: http.MethodPost,
+			Body:   CR{"status": "published"},
+			Header: map[string]string{"Preference-Applied": ""},
+			Result: CR{"response": CR{"updated": 1}},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+}
+
+func TestETag(t *testing.T) {
+	db := OpenTestDB()
+	defer db.Close()
+
+	PrepareTestApis(db)
+
+	ts := httptest.NewServer(NewTestExplorer(db))
+	defer ts.Close()
+
+	do := func(method, path string, header map[string]string, body interface{}) *http.Response {
+		data, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, ts.URL+path, bytes.NewReader(data))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		return resp
+	}
+
+	resp := do(http.MethodGet, "/items/1", nil, nil)
+	etag := resp.Header.Get("ETag")
+	if len(etag) < 3 || etag[0] != '"' {
+		t.Fatalf("bad etag %q", etag)
+	}
+
+	// ETag считается по записи целиком и не зависит от select
+	if selected := do(http.MethodGet, "/items/1?select=title", nil, nil).Header.Get("ETag"); selected != etag {
+		t.Fatalf("etag depends on select: %q != %q", selected, etag)
+	}
+
+	if resp = do(http.MethodGet, "/items/1", map[string]string{"If-None-Match": "W/" + etag}, nil); resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("If-None-Match: expected 304, got %d", resp.StatusCode)
+	}
+
+	if resp = do(http.MethodGet, "/items/1", map[string]string{"If-None-Match": `"other"`}, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("If-None-Match other: expected 200, got %d", resp.StatusCode)
+	}
+
+	cases := []Case{
+		Case{
+			Path:          "/items/1",
+			Method:        http.MethodPost,
+			RequestHeader: map[string]string{"If-Match": etag},
+			Body:          CR{"title": "first admin"},
+			Result:        CR{"response": CR{"updated": 1}},
+		},
+		Case{
+			Path:          "/items/1",
+			Method:        http.MethodPost,
+			RequestHeader: map[string]string{"If-Match": etag},
+			Body:          CR{"title": "second admin"},
+			Status:        http.StatusPreconditionFailed,
+			Result:        CR{"error": "precondition failed"},
+		},
+		Case{
+			Path:          "/items/1",
+			Method:        http.MethodDelete,
+			RequestHeader: map[string]string{"If-Match": etag},
+			Status:        http.StatusPreconditionFailed,
+			Result:        CR{"error": "precondition failed"},
+		},
+		Case{
+			Path:          "/items/42",
+			Method:        http.MethodDelete,
+			RequestHeader: map[string]string{"If-Match": "*"},
+			Status:        http.StatusPreconditionFailed,
+			Result:        CR{"error": "precondition failed"},
+		},
+		Case{
+			Path:   "/items/1",
+			Query:  "select=title",
+			Result: CR{"response": CR{"record": CR{"title": "first admin"}}},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+
+	resp = do(http.MethodGet, "/items/2", nil, nil)
+	if resp = do(http.MethodDelete, "/items/2", map[string]string{"If-Match": resp.Header.Get("ETag")}, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("delete with current etag: %d", resp.StatusCode)
+	}
+}
+
+func TestPatch(t *testing.T) {
+	db := OpenTestDB()
+	defer db.Close()
+
+	_, err := db.Exec(`CREATE TABLE docs (
+  id INTEGER PRIMARY KEY,
+  title text NOT NULL,
+  note text,
+  meta json DEFAULT NULL
+);
+INSERT INTO docs (id, title, note, meta) VALUES (1, 'draft', 'todo', '{"tags":["a","b"],"owner":{"name":"rvasily","team":"go"}}');`)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(NewTestExplorer(db))
+	defer ts.Close()
+
+	mergePatch := map[string]string{"Content-Type": "application/merge-patch+json", "Prefer": "return=representation"}
+	jsonPatch := map[string]string{"Content-Type": "application/json-patch+json", "Prefer": "return=representation"}
+	cases := []Case{
+		Case{
+			Path:          "/docs/1",
+			Method:        http.MethodPatch,
+			RequestHeader: mergePatch,
+			Body:          CR{"title": "final", "note": nil, "meta": CR{"owner": CR{"team": nil}}},
+			Result: CR{
+				"response": CR{
+					"updated": 1,
+					"record": CR{
+						"id":    1,
+						"title": "final",
+						"note":  nil,
+						"meta":  CR{"tags": []interface{}{"a", "b"}, "owner": CR{"name": "rvasily"}},
+					},
+				},
+			},
+		},
+		Case{
+			Path:          "/docs/1",
+			Method:        http.MethodPatch,
+			RequestHeader: jsonPatch,
+			Body: []CR{
+				{"op": "test", "path": "/title", "value": "final"},
+				{"op": "add", "path": "/meta/tags/-", "value": "c"},
+				{"op": "remove", "path": "/meta/tags/0"},
+				{"op": "copy", "from": "/title", "path": "/note"},
+				{"op": "move", "from": "/meta/owner", "path": "/meta/author"},
+			},
+			Result: CR{
+				"response": CR{
+					"updated": 1,
+					"record": CR{
+						"id":    1,
+						"title": "final",
+						"note":  "final",
+						"meta":  CR{"tags": []interface{}{"b", "c"}, "author": CR{"name": "rvasily"}},
+					},
+				},
+			},
+		},
+		Case{
+			Path:          "/docs/1",
+			Method:        http.MethodPatch,
+			RequestHeader: jsonPatch,
+			Body: []CR{
+				{"op": "replace", "path": "/note", "value": "lost"},
+				{"op": "test", "path": "/title", "value": "draft"},
+			},
+			Status: http.StatusConflict,
+			Result: CR{"error": "operation 1: test failed: /title"},
+		},
+		Case{
+			Path:          "/docs/1",
+			Method:        http.MethodPatch,
+			RequestHeader: jsonPatch,
+			Body:          []CR{{"op": "replace", "path": "/missing", "value": 1}},
+			Status:        http.StatusBadRequest,
+			Result:        CR{"error": "operation 0: path not found: missing"},
+		},
+		Case{
+			Path:          "/docs/1",
+			Method:        http.MethodPatch,
+			RequestHeader: mergePatch,
+			Body:          CR{"id": 2},
+			Status:        http.StatusBadRequest,
+			Result:        CR{"error": "field id have invalid type"},
+		},
+		Case{
+			Path:          "/docs/1",
+			Method:        http.MethodPatch,
+			RequestHeader: mergePatch,
+			Body:          CR{"title": nil},
+			Status:        http.StatusBadRequest,
+			Result: CR{
+				"error":    "invalid record",
+				"response": CR{"errors": []CR{{"pointer": "/title", "error": "is required"}}},
+			},
+		},
+		Case{
+			Path:          "/docs/1",
+			Method:        http.MethodPatch,
+			RequestHeader: map[string]string{"Content-Type": "text/plain"},
+			Body:          CR{"title": "x"},
+			Status:        http.StatusUnsupportedMediaType,
+			Result:        CR{"error": "unsupported content type: text/plain"},
+		},
+		Case{
+			Path:   "/docs/42",
+			Method: http.MethodPatch,
+			Body:   CR{"title": "x"},
+			Status: http.StatusNotFound,
+			Result: CR{"error": "record not found"},
+		},
+		Case{
+			Path:   "/docs/1",
+			Result: CR{"response": CR{"record": CR{"id": 1, "title": "final", "note": "final", "meta": CR{"tags": []interface{}{"b", "c"}, "author": CR{"name": "rvasily"}}}}},
+		},
+		// полная замена: note и meta не переданы и становятся NULL
+		Case{
+			Path:   "/docs/1",
+			Method: http.MethodPut,
+			Body:   CR{"id": 1, "title": "replaced"},
+			Result: CR{"response": CR{"updated": 1}},
+		},
+		Case{
+			Path:   "/docs/1",
+			Result: CR{"response": CR{"record": CR{"id": 1, "title": "replaced", "note": nil, "meta": nil}}},
+		},
+		Case{
+			Path:   "/docs/1",
+			Method: http.MethodPut,
+			Body:   CR{"note": "no title"},
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error":    "invalid record",
+				"response": CR{"errors": []CR{{"pointer": "/title", "error": "is required"}}},
+			},
+		},
+		Case{
+			Path:   "/docs/1",
+			Method: http.MethodPut,
+			Body:   CR{"id": 2, "title": "moved"},
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "field id have invalid type"},
+		},
+		Case{
+			Path:   "/docs/42",
+			Method: http.MethodPut,
+			Body:   CR{"title": "x"},
+			Status: http.StatusNotFound,
+			Result: CR{"error": "record not found"},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+}
+
+func TestSchema(t *testing.T) {
+	db := OpenTestDB()
+	defer db.Close()
+
+	PrepareBlogTables(db)
+	_, err := db.Exec(`CREATE INDEX posts_title ON posts (title);
+CREATE TABLE pages (id INTEGER PRIMARY KEY, slug varchar(64) NOT NULL UNIQUE, status varchar(16) DEFAULT 'draft');`)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(NewTestExplorer(db))
+	defer ts.Close()
+
+	cases := []Case{
+		Case{
+			Path: "/posts/_schema",
+			Result: CR{
+				"response": CR{
+					"name": "posts",
+					"columns": []CR{
+						{"name": "id", "type": "int", "nullable": false, "default": nil, "primary_key": true, "auto_increment": true, "comment": ""},
+						{"name": "title", "type": "text", "nullable": false, "default": nil, "primary_key": false, "auto_increment": false, "comment": ""},
+						{"name": "author_id", "type": "int", "nullable": true, "default": nil, "primary_key": false, "auto_increment": false, "comment": ""},
+					},
+					"primary_key":  []interface{}{"id"},
+					"unique":       []interface{}{[]interface{}{"id"}},
+					"indexes":      []CR{{"name": "posts_title", "columns": []interface{}{"title"}, "unique": false}},
+					"foreign_keys": []CR{{"column": "author_id", "ref_table": "authors", "ref_column": "id"}},
+				},
+			},
+		},
+		Case{
+			Path: "/pages/_schema",
+			Result: CR{
+				"response": CR{
+					"name": "pages",
+					"columns": []CR{
+						{"name": "id", "type": "int", "nullable": false, "default": nil, "primary_key": true, "auto_increment": true, "comment": ""},
+						{"name": "slug", "type": "varchar(64)", "nullable": false, "default": nil, "primary_key": false, "auto_increment": false, "comment": ""},
+						{"name": "status", "type": "varchar(16)", "nullable": true, "default": "'draft'", "primary_key": false, "auto_increment": false, "comment": ""},
+					},
+					"primary_key":  []interface{}{"id"},
+					"unique":       []interface{}{[]interface{}{"id"}, []interface{}{"slug"}},
+					"indexes":      []CR{{"name": "sqlite_autoindex_pages_1", "columns": []interface{}{"slug"}, "unique": true}},
+					"foreign_keys": []CR{},
+				},
+			},
+		},
+		Case{
+			Path:   "/nope/_schema",
+			Status: http.StatusNotFound,
+			Result: CR{"error": "unknown table"},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+
+	tables := getJson(t, ts.URL+"/_schema")["response"].(map[string]interface{})["tables"].([]interface{})
+	var names []string
+	for _, table := range tables {
+		names = append(names, table.(map[string]interface{})["name"].(string))
+	}
+	if !reflect.DeepEqual(names, []string{"authors", "comments", "pages", "posts"}) {
+		t.Fatalf("unexpected tables in /_schema: %v", names)
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	db := OpenTestDB()
+	defer db.Close()
+
+	PrepareTestApis(db)
+	PrepareBlogTables(db)
+
+	ts := httptest.NewServer(NewTestExplorer(db))
+	defer ts.Close()
+
+	spec := getJson(t, ts.URL+"/_openapi.json")
+	if spec["openapi"] != "3.1.0" {
+		t.Fatalf("bad openapi version: %v", spec["openapi"])
+	}
+
+	paths := spec["paths"].(map[string]interface{})
+	for _, path := range []string{"/", "/_batch", "/items", "/items/{id}", "/users/_schema", "/posts/{id}/comments", "/authors/{id}/posts",
+		"/_openapi.json", "/_schema", "/_schema/tables", "/_schema/tables/{table}", "/_admin/reload-schema",
+		"/_admin/migrations", "/_admin/migrations/up", "/_admin/migrations/down"} {
+		if _, ok := paths[path]; !ok {
+			t.Errorf("path %s is missing", path)
+		}
+	}
+
+	for _, method := range []string{"get", "put", "patch", "delete"} {
+		if _, ok := paths["/items"].(map[string]interface{})[method]; !ok {
+			t.Errorf("GET /items: method %s is missing", method)
+		}
+	}
+
+	schemas := spec["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	expected := CR{
+		"type": "object",
+		"properties": CR{
+			"title":       CR{"type": "string", "maxLength": 255},
+			"description": CR{"type": "string"},
+			"updated":     CR{"type": []interface{}{"string", "null"}, "maxLength": 255},
+		},
+		"required": []interface{}{"title", "description"},
+	}
+	var want interface{}
+	data, _ := json.Marshal(expected)
+	json.Unmarshal(data, &want)
+	if !reflect.DeepEqual(schemas["items.create"], want) {
+		t.Errorf("items.create schema does not match:\nGot : %#v\nWant: %#v", schemas["items.create"], want)
+	}
+
+	if _, ok := schemas["items.update"].(map[string]interface{})["properties"].(map[string]interface{})["id"]; ok {
+		t.Error("primary key must not be in items.update")
+	}
+
+	// во вложенной коллекции внешний ключ берётся из пути
+	nested := schemas["comments.create.post_id"].(map[string]interface{})
+	if _, ok := nested["properties"].(map[string]interface{})["post_id"]; ok || !reflect.DeepEqual(nested["required"], []interface{}{"body"}) {
+		t.Errorf("comments.create.post_id schema: %v", nested)
+	}
+
+	// каждая описанная операция должна доходить до хендлера, а не упираться в маршрутизацию
+	handler := NewTestExplorer(db)
+	for path, item := range paths {
+		for method := range item.(map[string]interface{}) {
+			if method == "parameters" {
+				continue
+			}
+
+			url := strings.NewReplacer("{id}", "1", "{table}", "items").Replace(path)
+			req := httptest.NewRequest(strings.ToUpper(method), url, bytes.NewReader([]byte("{}")))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			var body map[string]interface{}
+			json.Unmarshal(rec.Body.Bytes(), &body)
+			if body["error"] == "bad method" || body["error"] == "unknown method" {
+				t.Errorf("%s %s is documented, but not routed: %d %v", strings.ToUpper(method), path, rec.Code, body["error"])
+			}
+		}
+	}
+}
+
+func TestSchemaOverrides(t *testing.T) {
+	db := OpenTestDB()
+	defer db.Close()
+
+	PrepareTestApis(db)
+
+	file, err := ioutil.TempFile("", "overrides*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(`{"users": {"properties": {"email": {"type": "string", "format": "email"}, "login": {"type": "string", "minLength": 3}}}}`)
+	file.Close()
+
+	SchemaOverridesFile = file.Name()
+	defer func() { SchemaOverridesFile = "" }()
+
+	ts := httptest.NewServer(NewTestExplorer(db))
+	defer ts.Close()
+
+	cases := []Case{
+		Case{
+			Path:   "/users/",
+			Method: http.MethodPut,
+			Body:   CR{"login": "ab", "password": 1, "email": "nope", "info": ""},
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "invalid record",
+				"response": CR{
+					"errors": []CR{
+						{"pointer": "/email", "error": "must be a valid email"},
+						{"pointer": "/login", "error": "must be at least 3 characters"},
+						{"pointer": "/password", "error": "must be string"},
+					},
+				},
+			},
+		},
+		Case{
+			Path:   "/users/1",
+			Method: http.MethodPost,
+			Body:   CR{"email": "rvasily@example.com"},
+			Result: CR{"response": CR{"updated": 1}},
+		},
+		Case{
+			Path:   "/users/1",
+			Method: http.MethodPost,
+			Body:   CR{"email": "rvasily"},
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error":    "invalid record",
+				"response": CR{"errors": []CR{{"pointer": "/email", "error": "must be a valid email"}}},
+			},
+		},
+		// переопределения действуют на всех путях записи
+		Case{
+			Path:          "/users/1",
+			Method:        http.MethodPatch,
+			RequestHeader: map[string]string{"Content-Type": "application/merge-patch+json"},
+			Body:          CR{"email": "nope"},
+			Status:        http.StatusBadRequest,
+			Result:        CR{"error": "invalid record", "response": CR{"errors": []CR{{"pointer": "/email", "error": "must be a valid email"}}}},
+		},
+		Case{
+			Path:   "/users?user_id=eq.1",
+			Method: http.MethodPatch,
+			Body:   CR{"email": "nope"},
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "invalid record", "response": CR{"errors": []CR{{"pointer": "/email", "error": "must be a valid email"}}}},
+		},
+		Case{
+			Path:   "/_batch",
+			Method: http.MethodPost,
+			Body: CR{"operations": []CR{
+				{"op": "update", "table": "users", "id": 1, "body": CR{"email": "nope"}},
+			}},
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error":    "operation 0: invalid record",
+				"response": CR{"index": 0, "errors": []CR{{"pointer": "/email", "error": "must be a valid email"}}},
+			},
+		},
+		Case{
+			Path:   "/_batch",
+			Method: http.MethodPost,
+			Body: CR{"operations": []CR{
+				{"op": "create", "table": "users", "body": CR{"login": "bob", "password": "x", "email": "nope", "info": ""}},
+			}},
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error":    "operation 0: invalid record",
+				"response": CR{"index": 0, "errors": []CR{{"pointer": "/email", "error": "must be a valid email"}}},
+			},
+		},
+		Case{
+			Path:   "/users/1",
+			Query:  "select=email",
+			Result: CR{"response": CR{"record": CR{"email": "rvasily@example.com"}}},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+
+	schema := getJson(t, ts.URL+"/users/_schema.json")
+	if schema["$schema"] != "https://json-schema.org/draft/2020-12/schema" {
+		t.Errorf("bad $schema: %v", schema["$schema"])
+	}
+	email := schema["properties"].(map[string]interface{})["email"].(map[string]interface{})
+	if email["format"] != "email" {
+		t.Errorf("override is not applied: %v", email)
+	}
+
+	SchemaOverridesFile = file.Name() + ".missing"
+	if _, err := NewDbExplorerWithDialect(db, SQLiteDialect{}); err == nil {
+		t.Error("missing overrides file must fail")
+	}
+}
+
+func TestSchemaReload(t *testing.T) {
+	db := OpenTestDB()
+	defer db.Close()
+
+	PrepareTestApis(db)
+
+	handler := NewTestExplorer(db)
+	cache := handler.(*SchemaCache)
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	if _, err := db.Exec(`ALTER TABLE items ADD COLUMN views int DEFAULT 0`); err != nil {
+		panic(err)
+	}
+
+	// до перечитывания новой колонки не видно
+	before := cache.Explorer()
+	cases := []Case{
+		Case{
+			Path:   "/items/1",
+			Query:  "select=views",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "unknown column: views"},
+		},
+		Case{
+			Path:   "/_admin/reload-schema",
+			Method: http.MethodPost,
+			Result: CR{"response": CR{"reloaded": true, "tables": 2}},
+		},
+		Case{
+			Path:   "/items/1",
+			Query:  "select=views",
+			Result: CR{"response": CR{"record": CR{"views": 0}}},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+
+	// уже выданный explorer не меняется: начатые запросы дорабатывают со старой схемой
+	if len(before.columnTypes["items"]) != 4 || len(cache.Explorer().columnTypes["items"]) != 5 {
+		t.Fatalf("schema is not swapped: %d -> %d", len(before.columnTypes["items"]), len(cache.Explorer().columnTypes["items"]))
+	}
+
+	if changed, err := cache.reloadIfChanged(); err != nil || changed {
+		t.Fatalf("nothing changed, but reloadIfChanged = %v, %v", changed, err)
+	}
+
+	if _, err := db.Exec(`CREATE TABLE notes (id INTEGER PRIMARY KEY, body text)`); err != nil {
+		panic(err)
+	}
+
+	if changed, err := cache.reloadIfChanged(); err != nil || !changed {
+		t.Fatalf("new table, but reloadIfChanged = %v, %v", changed, err)
+	}
+
+	if _, ok := cache.Explorer().columnTypes["notes"]; !ok {
+		t.Fatal("new table is not loaded")
+	}
+
+	if _, ok := getJson(t, ts.URL+"/_openapi.json")["paths"].(map[string]interface{})["/notes"]; !ok {
+		t.Fatal("openapi is not rebuilt")
+	}
+}
+
+func TestDDL(t *testing.T) {
+	db := OpenTestDB()
+	defer db.Close()
+
+	PrepareTestApis(db)
+
+	handler := NewTestExplorer(db)
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	notes := CR{
+		"name": "notes",
+		"columns": []CR{
+			{"name": "id", "type": "int", "primary_key": true, "auto_increment": true},
+			{"name": "title", "type": "varchar(64)", "default": "it's new"},
+			{"name": "done", "type": "bool", "default": false},
+		},
+		"indexes": []CR{{"columns": []string{"title"}}},
+	}
+	notesDDL := []string{
+		`CREATE TABLE "notes" ("id" INTEGER PRIMARY KEY AUTOINCREMENT, "title" varchar(64) NOT NULL DEFAULT 'it''s new', "done" tinyint(1) NOT NULL DEFAULT 0)`,
+		`CREATE INDEX "notes_title_idx" ON "notes" ("title")`,
+	}
+
+	cases := []Case{
+		Case{
+			Path:   "/_schema/tables?dry_run=1",
+			Method: http.MethodPost,
+			Body:   notes,
+			Result: CR{"response": CR{"ddl": notesDDL, "dry_run": true}},
+		},
+		Case{
+			Path:   "/notes",
+			Status: http.StatusNotFound,
+			Result: CR{"error": "unknown table"},
+		},
+		Case{
+			Path:   "/_schema/tables",
+			Method: http.MethodPost,
+			Body:   notes,
+			Result: CR{"response": CR{"ddl": notesDDL}},
+		},
+		// схема перечитана сразу, таблицей можно пользоваться
+		Case{
+			Path:   "/notes/",
+			Method: http.MethodPut,
+			Body:   CR{"title": "first", "done": true},
+			Result: CR{"response": CR{"id": 1}},
+		},
+		Case{
+			Path:   "/notes/1",
+			Result: CR{"response": CR{"record": CR{"id": 1, "title": "first", "done": true}}},
+		},
+		Case{
+			Path:   "/_schema/tables",
+			Method: http.MethodPost,
+			Body:   notes,
+			Status: http.StatusConflict,
+			Result: CR{"error": "table already exists: notes"},
+		},
+		Case{
+			Path:   "/_schema/tables",
+			Method: http.MethodPost,
+			Body:   CR{"name": "bad", "columns": []CR{{"name": "id", "type": "int); DROP TABLE items; --"}}},
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "unsupported type: int); DROP TABLE items; --"},
+		},
+		Case{
+			Path:   "/_schema/tables/notes",
+			Method: http.MethodPatch,
+			Body: CR{
+				"rename_columns": CR{"title": "name"},
+				"drop_columns":   []string{"done"},
+				"add_columns":    []CR{{"name": "rank", "type": "int unsigned", "nullable": true}},
+				"drop_indexes":   []string{"notes_title_idx"},
+				"add_indexes":    []CR{{"name": "notes_name_rank", "columns": []string{"name", "rank"}, "unique": true}},
+			},
+			Result: CR{"response": CR{"ddl": []string{
+				`ALTER TABLE "notes" RENAME COLUMN "title" TO "name"`,
+				`ALTER TABLE "notes" DROP COLUMN "done"`,
+				`ALTER TABLE "notes" ADD COLUMN "rank" int unsigned`,
+				`DROP INDEX "notes_title_idx"`,
+				`CREATE UNIQUE INDEX "notes_name_rank" ON "notes" ("name", "rank")`,
+			}}},
+		},
+		Case{
+			Path:   "/notes/1",
+			Result: CR{"response": CR{"record": CR{"id": 1, "name": "first", "rank": nil}}},
+		},
+		Case{
+			Path:   "/_schema/tables/notes",
+			Method: http.MethodPatch,
+			Body:   CR{"drop_columns": []string{"done"}},
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "unknown column: done"},
+		},
+		Case{
+			Path:   "/_schema/tables/notes",
+			Method: http.MethodPatch,
+			Body:   CR{"modify_columns": []CR{{"name": "name", "type": "text"}}},
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "sqlite cannot modify columns, recreate the table instead"},
+		},
+		// команда упала в базе: ошибка - 400, схема всё равно перечитана, в ответе команды
+		Case{
+			Path:   "/_schema/tables/notes",
+			Method: http.MethodPatch,
+			Body:   CR{"add_columns": []CR{{"name": "score", "type": "int"}}},
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error":    `ALTER TABLE "notes" ADD COLUMN "score" int NOT NULL: SQL logic error: Cannot add a NOT NULL column with default value NULL (1)`,
+				"response": CR{"ddl": []string{`ALTER TABLE "notes" ADD COLUMN "score" int NOT NULL`}},
+			},
+		},
+		Case{
+			Path:   "/_schema/tables/missing",
+			Method: http.MethodPatch,
+			Body:   CR{"drop_columns": []string{"id"}},
+			Status: http.StatusNotFound,
+			Result: CR{"error": "unknown table"},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+
+	if _, err := db.Exec("SELECT 1 FROM items"); err != nil {
+		t.Fatalf("items damaged: %v", err)
+	}
+}
+
+func TestMigrationsApi(t *testing.T) {
+	db := OpenTestDB()
+	defer db.Close()
+
+	PrepareTestApis(db)
+
+	MigrationsDir = writeMigrations(t, map[string]string{
+		"001_notes.up.sql":   "CREATE TABLE notes (id INTEGER PRIMARY KEY, title text)",
+		"001_notes.down.sql": "DROP TABLE notes",
+		"002_broken.up.sql":  "ALTER TABLE missing ADD COLUMN x int",
+	})
+	defer func() { os.RemoveAll(MigrationsDir); MigrationsDir = "migrations" }()
+
+	handler := NewTestExplorer(db)
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	cases := []Case{
+		Case{
+			Path: "/_admin/migrations",
+			Result: CR{"response": CR{"migrations": []CR{
+				{"version": "001", "name": "notes", "state": "pending"},
+				{"version": "002", "name": "broken", "state": "pending"},
+			}}},
+		},
+		// вторая миграция падает и откатывается, первая остаётся применённой, схема перечитана
+		Case{
+			Path:   "/_admin/migrations/up",
+			Method: http.MethodPost,
+			Status: http.StatusInternalServerError,
+			Result: CR{"error": "002_broken: SQL logic error: no such table: missing (1)", "response": CR{"applied": []string{"001"}}},
+		},
+		// служебные таблицы мигратора через api не видны и не правятся
+		Case{
+			Path:   "/",
+			Result: CR{"response": CR{"tables": []string{"items", "notes", "users"}}},
+		},
+		Case{
+			Path:   "/schema_migrations_lock",
+			Method: http.MethodDelete,
+			Status: http.StatusNotFound,
+			Result: CR{"error": "unknown table"},
+		},
+		Case{
+			Path:   "/notes/",
+			Method: http.MethodPut,
+			Body:   CR{"title": "first"},
+			Result: CR{"response": CR{"id": 1}},
+		},
+		Case{
+			Path:   "/_admin/migrations/down?steps=x",
+			Method: http.MethodPost,
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "bad steps: x"},
+		},
+		Case{
+			Path:   "/_admin/migrations/down",
+			Method: http.MethodPost,
+			Result: CR{"response": CR{"reverted": []string{"001"}}},
+		},
+		Case{
+			Path:   "/notes",
+			Status: http.StatusNotFound,
+			Result: CR{"error": "unknown table"},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+}
+
+func TestBadJson(t *testing.T) {
+	db := OpenTestDB()
+	defer db.Close()
+
+	PrepareTestApis(db)
+
+	ts := httptest.NewServer(NewTestExplorer(db))
+	defer ts.Close()
+
+	cases := []Case{
+		Case{
+			Path:   "/items/1",
+			Method: http.MethodPost,
+			Body:   "not json",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "bad json"},
+		},
+		Case{
+			Path:   "/items/",
+			Method: http.MethodPut,
+			Body:   "not json",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "bad json"},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+}
+
+func TestLimitOffset(t *testing.T) {
+	db := OpenTestDB()
+	defer db.Close()
+
+	PrepareTestApis(db)
+
+	ts := httptest.NewServer(NewTestExplorer(db))
+	defer ts.Close()
+
+	cases := []Case{
+		// offset работает и без limit
+		Case{
+			Path:   "/items",
+			Query:  "offset=1&select=id",
+			Result: CR{"response": CR{"records": []CR{{"id": 2}}}},
+		},
+		Case{
+			Path:   "/items",
+			Query:  "limit=-1",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "bad limit: -1"},
+		},
+		Case{
+			Path:   "/items",
+			Query:  "offset=-1",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "bad offset: -1"},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+}
+
+func getJson(t *testing.T, url string) map[string]interface{} {
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result map[string]interface{}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		t.Fatalf("cant unpack json: %v", err)
+	}
+
+	return result
+}
+
+func TestCursorPagination(t *testing.T) {
+	db := OpenTestDB()
+	defer db.Close()
+
+	PrepareTestApis(db)
+	for i := 3; i <= 5; i++ {
+		_, err := db.Exec(`INSERT INTO items (title, description) VALUES (?, '')`, fmt.Sprintf("item %d", i))
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	ts := httptest.NewServer(NewTestExplorer(db))
+	defer ts.Close()
+
+	ids := func(response map[string]interface{}) []interface{} {
+		var ret []interface{}
+		for _, r := range response["records"].([]interface{}) {
+			ret = append(ret, r.(map[string]interface{})["id"])
+		}
+
+		return ret
+	}
+
+	page := getJson(t, ts.URL+"/items?limit=2&after=&select=title")["response"].(map[string]interface{})
+	if page["prev_cursor"] != nil || page["next_cursor"] == nil {
+		t.Fatalf("first page cursors: %v", page)
+	}
+	if title := page["records"].([]interface{})[0].(map[string]interface{}); len(title) != 1 {
+		t.Fatalf("order columns must not leak into select: %v", title)
+	}
+
+	page = getJson(t, ts.URL+"/items?limit=2&after=")["response"].(map[string]interface{})
+	page = getJson(t, ts.URL+"/items?limit=2&after="+page["next_cursor"].(string))["response"].(map[string]interface{})
+	if got := ids(page); !reflect.DeepEqual(got, []interface{}{3.0, 4.0}) {
+		t.Fatalf("second page: %v", got)
+	}
+
+	last := getJson(t, ts.URL+"/items?limit=2&after="+page["next_cursor"].(string))["response"].(map[string]interface{})
+	if got := ids(last); !reflect.DeepEqual(got, []interface{}{5.0}) || last["next_cursor"] != nil {
+		t.Fatalf("last page: %v", last)
+	}
+
+	prev := getJson(t, ts.URL+"/items?limit=2&before="+page["prev_cursor"].(string))["response"].(map[string]interface{})
+	if got := ids(prev); !reflect.DeepEqual(got, []interface{}{1.0, 2.0}) || prev["prev_cursor"] != nil {
+		t.Fatalf("prev page: %v", prev)
+	}
+
+	desc := getJson(t, ts.URL+"/items?limit=3&order=id.desc&after=")["response"].(map[string]interface{})
+	desc = getJson(t, ts.URL+"/items?limit=3&order=id.desc&after="+desc["next_cursor"].(string))["response"].(map[string]interface{})
+	if got := ids(desc); !reflect.DeepEqual(got, []interface{}{2.0, 1.0}) {
+		t.Fatalf("desc page: %v", got)
+	}
+
+	bad := getJson(t, ts.URL+"/items?order=id.desc&after="+page["next_cursor"].(string))
+	if bad["error"] != "cursor does not match order" {
+		t.Fatalf("cursor with other order: %v", bad)
+	}
+
+	bad = getJson(t, ts.URL+"/items?order=updated&after=")
+	if bad["error"] != "cursor pagination requires not null order columns: updated" {
+		t.Fatalf("nullable order: %v", bad)
+	}
+}
+
+func runCases(t *testing.T, ts *httptest.Server, db *sql.DB, cases []Case) {
+	for idx, item := range cases {
+		var (
+			err      error
+			result   interface{}
+			expected interface{}
+			req      *http.Request
+		)
+
+		caseName := fmt.Sprintf("case %d: [%s] %s %s", idx, item.Method, item.Path, item.Query)
+
+		// если у вас случилась это ошибка - значит вы не делаете где-то rows.Close и у вас текут соединения с базой
+		// если такое случилось на первом тесте - значит вы не закрываете коннект где-то при иницаилизации в NewDbExplorer
+		if db.Stats().OpenConnections != 1 {
+			t.Fatalf("[%s] you have %d open connections, must be 1", caseName, db.Stats().OpenConnections)
+		}
+
+		if item.Method == "" || item.Method == http.MethodGet {
+			req, err = http.NewRequest(item.Method, ts.URL+item.Path+"?"+item.Query, nil)
+		} else {
+			data, err := json.Marshal(item.Body)
+			if err != nil {
+				panic(err)
+			}
+			reqBody := bytes.NewReader(data)
+			req, err = http.NewRequest(item.Method, ts.URL+item.Path, reqBody)
+			req.Header.Add("Content-Type", "application/json")
+		}
+
+		for k, v := range item.RequestHeader {
+			req.Header.Set(k, v)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("[%s] request error: %v", caseName, err)
+			continue
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+
+		// fmt.Printf("[%s] body: %s\n", caseName, string(body))
+		if item.Status == 0 {
+			item.Status = http.StatusOK
+		}
+
+		if resp.StatusCode != item.Status {
+			t.Fatalf("[%s] expected http status %v, got %v", caseName, item.Status, resp.StatusCode)
+			continue
+		}
+
+		for k, v := range item.Header {
+			if got := resp.Header.Get(k); got != v {
+				t.Fatalf("[%s] expected header %s: %q, got %q", caseName, k, v, got)
+			}
 		}
 
 		err = json.Unmarshal(body, &result)
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	migrationsTable     = "schema_migrations"
+	migrationsLockTable = "schema_migrations_lock"
+)
+
+// файлы миграций: 001_create_users.up.sql и 001_create_users.down.sql
+var migrationFile = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration - пара файлов одной версии. Down может не быть, тогда откатить миграцию нельзя
+type Migration struct {
+	Version  string
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// MigrationStatus - состояние миграции: pending - не применена, applied - применена,
+// changed - файл поменялся после применения, missing - применена, но файла больше нет
+type MigrationStatus struct {
+	Version   string `json:"version"`
+	Name      string `json:"name"`
+	State     string `json:"state"`
+	AppliedAt string `json:"applied_at,omitempty"`
+}
+
+type appliedMigration struct {
+	name      string
+	checksum  string
+	appliedAt string
+}
+
+// Migrator применяет миграции из каталога и записывает применённые в schema_migrations.
+// Файл выполняется одним Exec, для mysql в DSN нужен multiStatements=true, если в файле несколько команд.
+// Одновременно мигрировать может только один процесс: блокировка - строка в schema_migrations_lock
+type Migrator struct {
+	db      *sql.DB
+	dialect Dialect
+	dir     string
+}
+
+func NewMigrator(db *sql.DB, dialect Dialect, dir string) *Migrator {
+	return &Migrator{db: db, dialect: dialect, dir: dir}
+}
+
+func migrationChecksum(up string) string {
+	sum := sha256.Sum256([]byte(up))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// Load читает миграции из каталога в порядке версий
+func (m *Migrator) Load() ([]Migration, error) {
+	files, re := ioutil.ReadDir(m.dir)
+	if os.IsNotExist(re) {
+		// нет каталога - нет миграций
+		return []Migration{}, nil
+	}
+	if re != nil {
+		return nil, re
+	}
+
+	byVersion := map[string]*Migration{}
+	for _, f := range files {
+		parts := migrationFile.FindStringSubmatch(f.Name())
+		if f.IsDir() || parts == nil {
+			continue
+		}
+
+		body, fe := ioutil.ReadFile(filepath.Join(m.dir, f.Name()))
+		if fe != nil {
+			return nil, fe
+		}
+
+		version := strings.TrimLeft(parts[1], "0")
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: parts[1], Name: parts[2]}
+			byVersion[version] = migration
+		} else if migration.Version != parts[1] || migration.Name != parts[2] {
+			return nil, fmt.Errorf("duplicate migration version: %s", parts[1])
+		}
+
+		if parts[3] == "up" {
+			migration.Up = string(body)
+		} else {
+			migration.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if len(migration.Up) == 0 {
+			return nil, fmt.Errorf("no up migration for %s_%s", migration.Version, migration.Name)
+		}
+		migration.Checksum = migrationChecksum(migration.Up)
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		a, b := strings.TrimLeft(migrations[i].Version, "0"), strings.TrimLeft(migrations[j].Version, "0")
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+
+		return a < b
+	})
+
+	return migrations, nil
+}
+
+func (m *Migrator) ensureTables() error {
+	_, e := m.db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version varchar(64) NOT NULL PRIMARY KEY, "+
+		"name varchar(255) NOT NULL, checksum varchar(64) NOT NULL, applied_at varchar(32) NOT NULL)",
+		m.dialect.QuoteIdent(migrationsTable)))
+	if e != nil {
+		return e
+	}
+
+	_, e = m.db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id int NOT NULL PRIMARY KEY, "+
+		"locked_by varchar(255) NOT NULL, locked_at varchar(32) NOT NULL)",
+		m.dialect.QuoteIdent(migrationsLockTable)))
+
+	return e
+}
+
+func (m *Migrator) readApplied(q queryer) (map[string]appliedMigration, error) {
+	rows, qe := q.Query(fmt.Sprintf("SELECT version, name, checksum, applied_at FROM %s", m.dialect.QuoteIdent(migrationsTable)))
+	if qe != nil {
+		return nil, qe
+	}
+	defer rows.Close()
+
+	applied := map[string]appliedMigration{}
+	for rows.Next() {
+		var version string
+		var a appliedMigration
+		if se := rows.Scan(&version, &a.name, &a.checksum, &a.appliedAt); se != nil {
+			return nil, se
+		}
+		applied[version] = a
+	}
+
+	return applied, rows.Err()
+}
+
+// lock занимает блокировку миграций: вставка строки с одним и тем же id удаётся только одному процессу.
+// Если процесс упал, не сняв блокировку, её снимают руками: DELETE FROM schema_migrations_lock
+func (m *Migrator) lock() (func(), error) {
+	host, _ := os.Hostname()
+	owner := fmt.Sprintf("%s:%d", host, os.Getpid())
+
+	_, ie := m.db.Exec(fmt.Sprintf("INSERT INTO %s (id, locked_by, locked_at) VALUES (1, %s, %s)",
+		m.dialect.QuoteIdent(migrationsLockTable), m.dialect.Placeholder(1), m.dialect.Placeholder(2)),
+		owner, time.Now().UTC().Format(time.RFC3339))
+	if ie != nil {
+		var by, at string
+		se := m.db.QueryRow(fmt.Sprintf("SELECT locked_by, locked_at FROM %s WHERE id = 1", m.dialect.QuoteIdent(migrationsLockTable))).Scan(&by, &at)
+		if se != nil {
+			return nil, ie
+		}
+
+		return nil, ApiError{http.StatusConflict, fmt.Errorf("migrations are locked by %s since %s", by, at)}
+	}
+
+	return func() {
+		m.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE id = 1", m.dialect.QuoteIdent(migrationsLockTable)))
+	}, nil
+}
+
+// isMigrationsTable - служебные таблицы мигратора, в api их не показываем
+func isMigrationsTable(table string) bool {
+	return table == migrationsTable || table == migrationsLockTable
+}
+
+// Status возвращает все миграции из каталога и применённые миграции, файлов которых уже нет.
+// Ничего не создаёт: если schema_migrations ещё нет, значит ничего не применено
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	migrations, le := m.Load()
+	if le != nil {
+		return nil, le
+	}
+
+	tables, te := m.dialect.ReadTables(m.db)
+	if te != nil {
+		return nil, te
+	}
+
+	applied := map[string]appliedMigration{}
+	for _, t := range tables {
+		if t != migrationsTable {
+			continue
+		}
+
+		var ae error
+		if applied, ae = m.readApplied(m.db); ae != nil {
+			return nil, ae
+		}
+	}
+
+	return migrationStatuses(migrations, applied), nil
+}
+
+func migrationStatuses(migrations []Migration, applied map[string]appliedMigration) []MigrationStatus {
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	known := map[string]bool{}
+
+	for _, migration := range migrations {
+		known[migration.Version] = true
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name, State: "pending"}
+
+		if a, ok := applied[migration.Version]; ok {
+			status.AppliedAt = a.appliedAt
+			status.State = "applied"
+			if a.checksum != migration.Checksum {
+				status.State = "changed"
+			}
+		}
+		statuses = append(statuses, status)
+	}
+
+	for version, a := range applied {
+		if !known[version] {
+			statuses = append(statuses, MigrationStatus{Version: version, Name: a.name, State: "missing", AppliedAt: a.appliedAt})
+		}
+	}
+
+	sort.SliceStable(statuses, func(i, j int) bool {
+		return statuses[i].State != "missing" && statuses[j].State == "missing"
+	})
+
+	return statuses
+}
+
+// prepare берёт блокировку, читает миграции и проверяет, что применённые с тех пор не менялись и не пропали
+func (m *Migrator) prepare() ([]Migration, map[string]appliedMigration, func(), error) {
+	if e := m.ensureTables(); e != nil {
+		return nil, nil, nil, e
+	}
+
+	migrations, le := m.Load()
+	if le != nil {
+		return nil, nil, nil, le
+	}
+
+	unlock, lockErr := m.lock()
+	if lockErr != nil {
+		return nil, nil, nil, lockErr
+	}
+
+	applied, ae := m.readApplied(m.db)
+	if ae != nil {
+		unlock()
+
+		return nil, nil, nil, ae
+	}
+
+	for _, status := range migrationStatuses(migrations, applied) {
+		switch status.State {
+		case "changed":
+			unlock()
+
+			return nil, nil, nil, ApiError{http.StatusConflict, fmt.Errorf("checksum mismatch: %s_%s was changed after it was applied", status.Version, status.Name)}
+		case "missing":
+			unlock()
+
+			return nil, nil, nil, ApiError{http.StatusConflict, fmt.Errorf("migration %s_%s is applied, but its file is missing", status.Version, status.Name)}
+		}
+	}
+
+	return migrations, applied, unlock, nil
+}
+
+// run выполняет sql миграции и меняет schema_migrations в одной транзакции
+func (m *Migrator) run(migration Migration, up bool) error {
+	tx, be := m.db.Begin()
+	if be != nil {
+		return be
+	}
+	defer tx.Rollback()
+
+	table := m.dialect.QuoteIdent(migrationsTable)
+	p := m.dialect.Placeholder
+
+	if up {
+		if _, e := tx.Exec(migration.Up); e != nil {
+			return fmt.Errorf("%s_%s: %v", migration.Version, migration.Name, e)
+		}
+
+		_, ie := tx.Exec(fmt.Sprintf("INSERT INTO %s (version, name, checksum, applied_at) VALUES (%s, %s, %s, %s)", table, p(1), p(2), p(3), p(4)),
+			migration.Version, migration.Name, migration.Checksum, time.Now().UTC().Format(time.RFC3339))
+		if ie != nil {
+			return ie
+		}
+	} else {
+		if _, e := tx.Exec(migration.Down); e != nil {
+			return fmt.Errorf("%s_%s: %v", migration.Version, migration.Name, e)
+		}
+
+		if _, de := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE version = %s", table, p(1)), migration.Version); de != nil {
+			return de
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Up применяет не больше steps неприменённых миграций по порядку, 0 - все. Возвращает применённые версии,
+// при ошибке - те, что успели примениться до неё
+func (m *Migrator) Up(steps int) ([]string, error) {
+	migrations, applied, unlock, pe := m.prepare()
+	if pe != nil {
+		return nil, pe
+	}
+	defer unlock()
+
+	done := []string{}
+	for _, migration := range migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		if steps > 0 && len(done) == steps {
+			break
+		}
+
+		if e := m.run(migration, true); e != nil {
+			return done, e
+		}
+		done = append(done, migration.Version)
+	}
+
+	return done, nil
+}
+
+// Down откатывает steps последних применённых миграций, 0 - одну
+func (m *Migrator) Down(steps int) ([]string, error) {
+	migrations, applied, unlock, pe := m.prepare()
+	if pe != nil {
+		return nil, pe
+	}
+	defer unlock()
+
+	if steps <= 0 {
+		steps = 1
+	}
+
+	done := []string{}
+	for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		migration := migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+
+		if len(migration.Down) == 0 {
+			return done, fmt.Errorf("no down migration for %s_%s", migration.Version, migration.Name)
+		}
+
+		if e := m.run(migration, false); e != nil {
+			return done, e
+		}
+		done = append(done, migration.Version)
+	}
+
+	return done, nil
+}
+
+// migrateCommand - подкоманда `migrate up|down|status [steps]`
+func migrateCommand(m *Migrator, args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return fmt.Errorf("usage: migrate up|down|status [steps]")
+	}
+
+	steps := 0
+	if len(args) == 2 {
+		n, ne := strconv.Atoi(args[1])
+		if ne != nil || n < 0 {
+			return fmt.Errorf("bad steps: %s", args[1])
+		}
+		steps = n
+	}
+
+	var (
+		done []string
+		e    error
+	)
+	switch args[0] {
+	case "status":
+		statuses, se := m.Status()
+		if se != nil {
+			return se
+		}
+
+		for _, s := range statuses {
+			fmt.Printf("%s_%s\t%s\t%s\n", s.Version, s.Name, s.State, s.AppliedAt)
+		}
+
+		return nil
+	case "up":
+		done, e = m.Up(steps)
+	case "down":
+		done, e = m.Down(steps)
+	default:
+		return fmt.Errorf("usage: migrate up|down|status [steps]")
+	}
+
+	for _, version := range done {
+		fmt.Printf("%s %s\n", args[0], version)
+	}
+	if len(done) == 0 && e == nil {
+		fmt.Println("nothing to migrate")
+	}
+
+	return e
+}
+
+func isMigrationsPath(path string) bool {
+	return path == "/_admin/migrations/up" || path == "/_admin/migrations/down"
+}
+
+//GET /_admin/migrations - состояние миграций
+func (explorer *DbExplorer) handleGetMigrations(w http.ResponseWriter, _ *http.Request) {
+	statuses, se := NewMigrator(explorer.db, explorer.dialect, MigrationsDir).Status()
+	panicOnError(se)
+
+	handleServerResponse(w, map[string]interface{}{"migrations": statuses})
+}
+
+//POST /_admin/migrations/up?steps=n, POST /_admin/migrations/down?steps=n - применить или откатить миграции
+//и перечитать схему. steps для up по умолчанию все, для down - одна
+func (explorer *DbExplorer) handleMigrate(w http.ResponseWriter, r *http.Request) {
+	steps := 0
+	if s := r.URL.Query().Get("steps"); len(s) > 0 {
+		n, ne := strconv.Atoi(s)
+		if ne != nil || n < 0 {
+			handleServerError(w, http.StatusBadRequest, fmt.Errorf("bad steps: %s", s))
+
+			return
+		}
+		steps = n
+	}
+
+	m := NewMigrator(explorer.db, explorer.dialect, MigrationsDir)
+	key := "applied"
+	migrate := m.Up
+	if r.URL.Path == "/_admin/migrations/down" {
+		key = "reverted"
+		migrate = m.Down
+	}
+
+	done, me := migrate(steps)
+	// схема могла поменяться, даже если одна из миграций упала
+	if len(done) > 0 {
+		panicOnError(explorer.cache.Reload())
+	}
+
+	// при ошибке в ответе остаются версии, которые успели примениться до неё
+	if me != nil {
+		status := http.StatusInternalServerError
+		if ae, ok := me.(ApiError); ok {
+			status, me = ae.HTTPStatus, ae.Err
+		}
+
+		w.WriteHeader(status)
+		w.Write(ServerError{Error: me.Error(), Response: map[string]interface{}{key: done}}.Marshal())
+
+		return
+	}
+
+	handleServerResponse(w, map[string]interface{}{key: done})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeMigrations(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, body := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func migrationStates(t *testing.T, m *Migrator) []string {
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	states := make([]string, len(statuses))
+	for i, s := range statuses {
+		states[i] = s.Version + " " + s.State
+	}
+
+	return states
+}
+
+func TestMigrator(t *testing.T) {
+	db := OpenTestDB()
+	defer db.Close()
+
+	dir := writeMigrations(t, map[string]string{
+		"2_add_body.up.sql":         "ALTER TABLE notes ADD COLUMN body text",
+		"2_add_body.down.sql":       "ALTER TABLE notes DROP COLUMN body",
+		"10_tags.up.sql":            "CREATE TABLE tags (id INTEGER PRIMARY KEY); CREATE TABLE note_tags (note_id int, tag_id int)",
+		"10_tags.down.sql":          "DROP TABLE note_tags; DROP TABLE tags",
+		"001_create_notes.up.sql":   "CREATE TABLE notes (id INTEGER PRIMARY KEY, title text)",
+		"001_create_notes.down.sql": "DROP TABLE notes",
+		"README.md":                 "not a migration",
+	})
+	defer os.RemoveAll(dir)
+
+	m := NewMigrator(db, SQLiteDialect{}, dir)
+
+	if got := migrationStates(t, m); !reflect.DeepEqual(got, []string{"001 pending", "2 pending", "10 pending"}) {
+		t.Fatalf("status: %v", got)
+	}
+
+	// статус только читает: служебные таблицы появляются при первом up
+	if tables, err := (SQLiteDialect{}).ReadTables(db); err != nil || len(tables) != 0 {
+		t.Fatalf("status created tables: %v %v", tables, err)
+	}
+
+	if got, err := NewMigrator(db, SQLiteDialect{}, filepath.Join(dir, "missing")).Status(); err != nil || len(got) != 0 {
+		t.Fatalf("status without dir: %v %v", got, err)
+	}
+
+	if done, err := m.Up(2); err != nil || !reflect.DeepEqual(done, []string{"001", "2"}) {
+		t.Fatalf("up 2: %v %v", done, err)
+	}
+
+	if done, err := m.Up(0); err != nil || !reflect.DeepEqual(done, []string{"10"}) {
+		t.Fatalf("up: %v %v", done, err)
+	}
+
+	if _, err := db.Exec("INSERT INTO note_tags VALUES (1, 1)"); err != nil {
+		t.Fatalf("migrations are not applied: %v", err)
+	}
+
+	if done, err := m.Down(2); err != nil || !reflect.DeepEqual(done, []string{"10", "2"}) {
+		t.Fatalf("down 2: %v %v", done, err)
+	}
+
+	if got := migrationStates(t, m); !reflect.DeepEqual(got, []string{"001 applied", "2 pending", "10 pending"}) {
+		t.Fatalf("status after down: %v", got)
+	}
+
+	// пока блокировка занята, второй процесс мигрировать не может
+	unlock, err := m.lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(0); err == nil || err.(ApiError).HTTPStatus != 409 {
+		t.Fatalf("up under lock: %v", err)
+	}
+	unlock()
+
+	// применённую миграцию поменяли - мигрировать дальше нельзя, пока не разберутся
+	if err := ioutil.WriteFile(filepath.Join(dir, "001_create_notes.up.sql"), []byte("CREATE TABLE notes (id INTEGER PRIMARY KEY)"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := migrationStates(t, m); !reflect.DeepEqual(got, []string{"001 changed", "2 pending", "10 pending"}) {
+		t.Fatalf("status after change: %v", got)
+	}
+
+	if _, err := m.Up(0); err == nil || err.Error() != "checksum mismatch: 001_create_notes was changed after it was applied" {
+		t.Fatalf("up with changed migration: %v", err)
+	}
+
+	os.Remove(filepath.Join(dir, "001_create_notes.up.sql"))
+	os.Remove(filepath.Join(dir, "001_create_notes.down.sql"))
+
+	if got := migrationStates(t, m); !reflect.DeepEqual(got, []string{"2 pending", "10 pending", "001 missing"}) {
+		t.Fatalf("status after remove: %v", got)
+	}
+
+	if _, err := m.Down(1); err == nil || err.Error() != "migration 001_create_notes is applied, but its file is missing" {
+		t.Fatalf("down with missing migration: %v", err)
+	}
+}
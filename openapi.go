@@ -0,0 +1,518 @@
+package main
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"sort"
+)
+
+// columnSchema описывает значение колонки в терминах JSON Schema 2020-12, на нём же построен OpenAPI 3.1.
+// Схема повторяет то, что принимает ParseByColumnType: границы чисел, длины строк, форматы дат
+func columnSchema(c ColumnInfo) map[string]Any {
+	ct := c.ColumnType
+	schema := map[string]Any{}
+
+	switch ct.Kind {
+	case KindInt:
+		bits, ok := intBits[ct.Base]
+		if !ok {
+			bits = 64
+		}
+
+		schema["type"] = "integer"
+		if ct.Unsigned {
+			schema["minimum"] = 0
+			schema["maximum"] = json.Number(new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), bits), big.NewInt(1)).String())
+		} else {
+			schema["minimum"] = json.Number(new(big.Int).Neg(new(big.Int).Lsh(big.NewInt(1), bits-1)).String())
+			schema["maximum"] = json.Number(new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), bits-1), big.NewInt(1)).String())
+		}
+	case KindYear:
+		// ParseYearValue принимает 0 и 1901..2155
+		schema["type"] = "integer"
+		schema["anyOf"] = []Any{
+			map[string]Any{"const": 0},
+			map[string]Any{"minimum": 1901, "maximum": 2155},
+		}
+	case KindBit:
+		schema["type"] = "integer"
+		schema["minimum"] = 0
+		schema["maximum"] = json.Number(new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), uint(ct.Length)), big.NewInt(1)).String())
+	case KindBool:
+		// ParseBoolValue принимает и 0/1
+		schema["enum"] = []Any{true, false, 0, 1}
+	case KindDecimal:
+		// decimal можно передать и строкой, чтобы не терять точность
+		schema["type"] = []Any{"number", "string"}
+		schema["pattern"] = `^-?[0-9]+(\.[0-9]+)?$`
+		if ct.Precision > 0 {
+			bound := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(ct.Precision-ct.Scale)), nil)
+			schema["exclusiveMaximum"] = json.Number(bound.String())
+			schema["exclusiveMinimum"] = json.Number(new(big.Int).Neg(bound).String())
+		}
+		if ct.Unsigned {
+			delete(schema, "exclusiveMinimum")
+			schema["minimum"] = 0
+		}
+	case KindFloat:
+		schema["type"] = "number"
+		if ct.Unsigned {
+			schema["minimum"] = 0
+		}
+	case KindDate:
+		schema["type"] = "string"
+		schema["format"] = "date"
+	case KindDateTime:
+		schema["type"] = "string"
+		schema["format"] = "date-time"
+	case KindTime:
+		schema["type"] = "string"
+		schema["pattern"] = `^[0-9]{2}:[0-9]{2}:[0-9]{2}(\.[0-9]{1,6})?$`
+	case KindJSON:
+		// в json-колонке может лежать что угодно, включая null
+		return schema
+	case KindBinary:
+		schema["type"] = "string"
+		schema["contentEncoding"] = "base64"
+	case KindEnum:
+		schema["type"] = "string"
+		schema["enum"] = stringsToAny(ct.Values)
+	case KindSet:
+		// set принимает и массив, и строку "a,b"
+		schema["type"] = []Any{"array", "string"}
+		schema["items"] = map[string]Any{"type": "string", "enum": stringsToAny(ct.Values)}
+		schema["uniqueItems"] = true
+	default:
+		schema["type"] = "string"
+		if ct.Length > 0 {
+			schema["maxLength"] = ct.Length
+		}
+	}
+
+	if c.Nullable {
+		if enum, ok := schema["enum"].([]Any); ok {
+			schema["enum"] = append(enum, nil)
+		}
+
+		switch t := schema["type"].(type) {
+		case string:
+			schema["type"] = []Any{t, "null"}
+		case []Any:
+			schema["type"] = append(t, "null")
+		}
+	}
+
+	if len(c.Comment) > 0 {
+		schema["description"] = c.Comment
+	}
+
+	return schema
+}
+
+// createRequired - колонки, без которых запись не вставить: не null, без значения по умолчанию и не автоинкремент
+func createRequired(columns []ColumnInfo) []string {
+	required := []string{}
+	for _, c := range columns {
+		if !c.Nullable && c.Default == nil && !c.AutoIncrement {
+			required = append(required, c.Name)
+		}
+	}
+
+	return required
+}
+
+// replaceRequired - колонки, которые обязательны при полной замене записи: пропущенная колонка стала бы NULL
+func replaceRequired(columns []ColumnInfo) []string {
+	required := []string{}
+	for _, c := range columns {
+		if !c.Nullable && !c.PrimaryKey {
+			required = append(required, c.Name)
+		}
+	}
+
+	return required
+}
+
+func objectSchema(columns []ColumnInfo, skip func(ColumnInfo) bool, required []string) map[string]Any {
+	properties := map[string]Any{}
+	for _, c := range columns {
+		if !skip(c) {
+			properties[c.Name] = columnSchema(c)
+		}
+	}
+
+	schema := map[string]Any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+
+	return schema
+}
+
+func schemaRef(name string) map[string]Any {
+	return map[string]Any{"$ref": "#/components/schemas/" + name}
+}
+
+func paramRef(name string) map[string]Any {
+	return map[string]Any{"$ref": "#/components/parameters/" + name}
+}
+
+func jsonContent(schema Any) map[string]Any {
+	return map[string]Any{"application/json": map[string]Any{"schema": schema}}
+}
+
+// okResponse заворачивает схему ответа в {"response": ...}, как это делает handleServerResponse
+func okResponse(properties map[string]Any) map[string]Any {
+	return map[string]Any{
+		"200": map[string]Any{
+			"description": "OK",
+			"content": jsonContent(map[string]Any{
+				"type":       "object",
+				"properties": map[string]Any{"response": map[string]Any{"type": "object", "properties": properties}},
+			}),
+		},
+		"default": map[string]Any{"description": "Error", "content": jsonContent(schemaRef("Error"))},
+	}
+}
+
+func operation(summary string, parameters []Any, body Any, response map[string]Any) map[string]Any {
+	op := map[string]Any{"summary": summary, "responses": okResponse(response)}
+	if len(parameters) > 0 {
+		op["parameters"] = parameters
+	}
+	if body != nil {
+		op["requestBody"] = map[string]Any{"required": true, "content": body}
+	}
+
+	return op
+}
+
+var (
+	integerSchema = map[string]Any{"type": "integer"}
+	anySchema     = map[string]Any{}
+)
+
+// openAPIParameters - служебные параметры списка из listParams
+var openAPIParameters = map[string]Any{
+	"limit":   queryParameter("limit", "page size", map[string]Any{"type": "integer", "minimum": 0}),
+	"offset":  queryParameter("offset", "records to skip", map[string]Any{"type": "integer", "minimum": 0}),
+	"order":   queryParameter("order", "sort order: order=title.asc,id.desc", map[string]Any{"type": "string"}),
+	"select":  queryParameter("select", "columns to return: select=id,title", map[string]Any{"type": "string"}),
+	"after":   queryParameter("after", "cursor of the next page", map[string]Any{"type": "string"}),
+	"before":  queryParameter("before", "cursor of the previous page", map[string]Any{"type": "string"}),
+	"count":   queryParameter("count", "return the total number of records", map[string]Any{"type": "string", "enum": []Any{"exact", "estimated"}}),
+	"embed":   queryParameter("embed", "related records to embed: embed=author,comments", map[string]Any{"type": "string"}),
+	"dry_run": queryParameter("dry_run", "only count the records under the filter", map[string]Any{"type": "string", "enum": []Any{"1", "true"}}),
+}
+
+func queryParameter(name, description string, schema Any) map[string]Any {
+	return map[string]Any{"name": name, "in": "query", "description": description, "schema": schema}
+}
+
+// filterParameters - по параметру на колонку: ?title=eq.memcache, ?id=in.(1,2), ?updated=is.null
+func filterParameters(columns []ColumnInfo) []Any {
+	params := make([]Any, 0, len(columns))
+	for _, c := range columns {
+		params = append(params, queryParameter(c.Name, "filter: eq, ne, gt, gte, lt, lte, in, like, ilike, is", map[string]Any{"type": "string"}))
+	}
+
+	return params
+}
+
+func (explorer *DbExplorer) tablePaths(tableName string, paths map[string]Any, schemas map[string]Any) {
+	columns := explorer.columnTypes[tableName]
+
+	schemas[tableName] = objectSchema(columns, func(ColumnInfo) bool { return false }, nil)
+	schemas[tableName+".create"] = objectSchema(columns, func(c ColumnInfo) bool { return c.AutoIncrement }, createRequired(columns))
+	schemas[tableName+".update"] = objectSchema(columns, func(c ColumnInfo) bool { return c.PrimaryKey }, nil)
+	schemas[tableName+".replace"] = objectSchema(columns, func(c ColumnInfo) bool { return c.PrimaryKey }, replaceRequired(columns))
+
+	record := schemaRef(tableName)
+	create := schemaRef(tableName + ".create")
+	update := schemaRef(tableName + ".update")
+	replace := schemaRef(tableName + ".replace")
+	filters := filterParameters(columns)
+
+	listParameters := []Any{paramRef("limit"), paramRef("offset"), paramRef("order"), paramRef("select"),
+		paramRef("after"), paramRef("before"), paramRef("count"), paramRef("embed")}
+	writeParameters := append(filters[:len(filters):len(filters)], paramRef("dry_run"))
+
+	paths["/"+tableName] = map[string]Any{
+		"get": operation("List "+tableName, append(listParameters, filters...), nil, map[string]Any{
+			"records":     map[string]Any{"type": "array", "items": record},
+			"total":       integerSchema,
+			"next_cursor": anySchema,
+			"prev_cursor": anySchema,
+		}),
+		"put": operation("Create "+tableName, nil, map[string]Any{
+			"application/json":     map[string]Any{"schema": map[string]Any{"oneOf": []Any{create, map[string]Any{"type": "array", "items": create}}}},
+			"application/x-ndjson": map[string]Any{"schema": create},
+		}, map[string]Any{
+			"inserted": integerSchema,
+			"ids":      map[string]Any{"type": "array"},
+			"record":   record,
+		}),
+		"patch":  operation("Update "+tableName+" under the filter", writeParameters, jsonContent(update), map[string]Any{"updated": integerSchema}),
+		"delete": operation("Delete "+tableName+" under the filter", writeParameters, nil, map[string]Any{"deleted": integerSchema}),
+	}
+
+	id := map[string]Any{"name": "id", "in": "path", "required": true, "schema": map[string]Any{"type": "string"},
+		"description": "primary key, composite keys as 1,go or item_id=1;tag=go"}
+	written := map[string]Any{"updated": integerSchema, "record": record}
+
+	paths["/"+tableName+"/{id}"] = map[string]Any{
+		"parameters": []Any{id},
+		"get":        operation("Get "+tableName, []Any{paramRef("select"), paramRef("embed")}, nil, map[string]Any{"record": record}),
+		"post":       operation("Update "+tableName, nil, jsonContent(update), written),
+		"put":        operation("Replace "+tableName, nil, jsonContent(replace), written),
+		"patch": operation("Patch "+tableName, nil, map[string]Any{
+			"application/merge-patch+json": map[string]Any{"schema": update},
+			"application/json-patch+json":  map[string]Any{"schema": map[string]Any{"type": "array", "items": schemaRef("PatchOperation")}},
+		}, written),
+		"delete": operation("Delete "+tableName, nil, nil, map[string]Any{"deleted": integerSchema}),
+	}
+
+	explorer.childPaths(tableName, paths, schemas, listParameters)
+
+	paths["/"+tableName+"/_schema"] = map[string]Any{
+		"get": operation("Describe "+tableName, nil, nil, map[string]Any{"columns": map[string]Any{"type": "array"}}),
+	}
+
+	paths["/"+tableName+"/_schema.json"] = map[string]Any{
+		"get": map[string]Any{
+			"summary":   "JSON Schema of " + tableName,
+			"responses": map[string]Any{"200": map[string]Any{"description": "OK", "content": map[string]Any{"application/schema+json": map[string]Any{"schema": anySchema}}}},
+		},
+	}
+}
+
+// childPaths описывает вложенные коллекции /$parent/{id}/$child. Если дочерняя таблица ссылается на родителя
+// несколькими колонками, маршрут отвечает 400 "ambiguous relation", такие коллекции не описываются
+func (explorer *DbExplorer) childPaths(tableName string, paths map[string]Any, schemas map[string]Any, listParameters []Any) {
+	byChild := map[string][]Relation{}
+	for _, rel := range explorer.relations(tableName) {
+		if rel.Many {
+			byChild[rel.Table] = append(byChild[rel.Table], rel)
+		}
+	}
+
+	for child, rels := range byChild {
+		if len(rels) != 1 {
+			continue
+		}
+		rel := rels[0]
+
+		columns := explorer.columnTypes[child]
+		var required []string
+		for _, name := range createRequired(columns) {
+			if name != rel.RefColumn {
+				required = append(required, name)
+			}
+		}
+		createName := child + ".create." + rel.RefColumn
+		schemas[createName] = objectSchema(columns, func(c ColumnInfo) bool { return c.AutoIncrement || c.Name == rel.RefColumn }, required)
+
+		filters := filterParameters(columns)
+		writeParameters := append(filters[:len(filters):len(filters)], paramRef("dry_run"))
+		id := map[string]Any{"name": "id", "in": "path", "required": true, "schema": map[string]Any{"type": "string"},
+			"description": tableName + "." + rel.Column + " of the parent record"}
+		record := schemaRef(child)
+
+		paths["/"+tableName+"/{id}/"+child] = map[string]Any{
+			"parameters": []Any{id},
+			"get": operation("List "+child+" of "+tableName, append(listParameters, filters...), nil, map[string]Any{
+				"records": map[string]Any{"type": "array", "items": record},
+				"total":   integerSchema,
+			}),
+			"put": operation("Create "+child+" of "+tableName+", "+rel.RefColumn+" is taken from the path", nil,
+				jsonContent(schemaRef(createName)), map[string]Any{"ids": map[string]Any{"type": "array"}, "record": record}),
+			"patch": operation("Update all "+child+" of "+tableName+", filters narrow them down", writeParameters,
+				jsonContent(schemaRef(child+".update")), map[string]Any{"updated": integerSchema}),
+			"delete": operation("Delete all "+child+" of "+tableName+", filters narrow them down", writeParameters, nil,
+				map[string]Any{"deleted": integerSchema}),
+		}
+	}
+}
+
+// servicePaths - служебные маршруты: схема, DDL, перечитывание схемы, миграции и само описание API
+func servicePaths(paths map[string]Any) {
+	paths["/_openapi.json"] = map[string]Any{
+		"get": map[string]Any{
+			"summary":   "This document",
+			"responses": map[string]Any{"200": map[string]Any{"description": "OK", "content": jsonContent(anySchema)}},
+		},
+	}
+
+	paths["/_schema/tables"] = map[string]Any{
+		"post": operation("Create a table", []Any{paramRef("dry_run")}, jsonContent(schemaRef("CreateTableSpec")), ddlResponse),
+	}
+
+	paths["/_schema/tables/{table}"] = map[string]Any{
+		"parameters": []Any{map[string]Any{"name": "table", "in": "path", "required": true, "schema": map[string]Any{"type": "string"}}},
+		"patch":      operation("Alter a table", []Any{paramRef("dry_run")}, jsonContent(schemaRef("AlterTableSpec")), ddlResponse),
+	}
+
+	paths["/_admin/reload-schema"] = map[string]Any{
+		"post": operation("Reload the database schema", nil, nil, map[string]Any{"reloaded": map[string]Any{"type": "boolean"}, "tables": integerSchema}),
+	}
+
+	paths["/_admin/migrations"] = map[string]Any{
+		"get": operation("Migration status", nil, nil, map[string]Any{"migrations": map[string]Any{"type": "array", "items": schemaRef("MigrationStatus")}}),
+	}
+
+	steps := queryParameter("steps", "number of migrations, up: 0 - all, down: 0 - one", map[string]Any{"type": "integer", "minimum": 0})
+	stringArray := map[string]Any{"type": "array", "items": map[string]Any{"type": "string"}}
+	paths["/_admin/migrations/up"] = map[string]Any{
+		"post": operation("Apply pending migrations", []Any{steps}, nil, map[string]Any{"applied": stringArray}),
+	}
+	paths["/_admin/migrations/down"] = map[string]Any{
+		"post": operation("Revert applied migrations", []Any{steps}, nil, map[string]Any{"reverted": stringArray}),
+	}
+}
+
+var ddlResponse = map[string]Any{
+	"ddl":          map[string]Any{"type": "array", "items": map[string]Any{"type": "string"}},
+	"dry_run":      map[string]Any{"type": "boolean"},
+	"reload_error": map[string]Any{"type": "string"},
+}
+
+// serviceSchemas - тела служебных маршрутов, повторяют CreateTableSpec, AlterTableSpec и MigrationStatus
+func serviceSchemas(schemas map[string]Any) {
+	str := map[string]Any{"type": "string"}
+	boolean := map[string]Any{"type": "boolean"}
+	names := map[string]Any{"type": "array", "items": str}
+
+	schemas["ColumnSpec"] = map[string]Any{
+		"type":     "object",
+		"required": []Any{"name", "type"},
+		"properties": map[string]Any{
+			"name":           str,
+			"type":           map[string]Any{"type": "string", "description": "column type, e.g. varchar(255), decimal(10,2), int unsigned"},
+			"nullable":       boolean,
+			"default":        map[string]Any{"type": []Any{"string", "number", "boolean", "null"}},
+			"primary_key":    boolean,
+			"auto_increment": boolean,
+			"unique":         boolean,
+			"comment":        str,
+		},
+	}
+	schemas["IndexSpec"] = map[string]Any{
+		"type":       "object",
+		"required":   []Any{"columns"},
+		"properties": map[string]Any{"name": str, "columns": names, "unique": boolean},
+	}
+	schemas["CreateTableSpec"] = map[string]Any{
+		"type":     "object",
+		"required": []Any{"name", "columns"},
+		"properties": map[string]Any{
+			"name":    str,
+			"columns": map[string]Any{"type": "array", "items": schemaRef("ColumnSpec")},
+			"indexes": map[string]Any{"type": "array", "items": schemaRef("IndexSpec")},
+		},
+	}
+	schemas["AlterTableSpec"] = map[string]Any{
+		"type": "object",
+		"properties": map[string]Any{
+			"rename_columns": map[string]Any{"type": "object", "additionalProperties": str},
+			"drop_columns":   names,
+			"modify_columns": map[string]Any{"type": "array", "items": schemaRef("ColumnSpec")},
+			"add_columns":    map[string]Any{"type": "array", "items": schemaRef("ColumnSpec")},
+			"drop_indexes":   names,
+			"add_indexes":    map[string]Any{"type": "array", "items": schemaRef("IndexSpec")},
+		},
+	}
+	schemas["MigrationStatus"] = map[string]Any{
+		"type": "object",
+		"properties": map[string]Any{
+			"version":    str,
+			"name":       str,
+			"state":      map[string]Any{"type": "string", "enum": []Any{"pending", "applied", "changed", "missing"}},
+			"applied_at": str,
+		},
+	}
+	// {"$ref": "0.id"} в id и значениях body - поле результата предыдущей операции
+	schemas["BatchRef"] = map[string]Any{
+		"type":        "object",
+		"required":    []Any{"$ref"},
+		"properties":  map[string]Any{"$ref": map[string]Any{"type": "string", "pattern": `^[0-9]+\..+$`}},
+		"description": "value from the result of an earlier operation: {\"$ref\": \"0.id\"} is the id field of operation 0",
+	}
+}
+
+// buildOpenAPI собирает описание API по текущей схеме базы
+func (explorer *DbExplorer) buildOpenAPI() ([]byte, error) {
+	tables := make([]string, 0, len(explorer.columnTypes))
+	for t := range explorer.columnTypes {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+
+	paths := map[string]Any{
+		"/": map[string]Any{
+			"get": operation("List tables", nil, nil, map[string]Any{"tables": map[string]Any{"type": "array", "items": map[string]Any{"type": "string"}}}),
+		},
+		"/_schema": map[string]Any{
+			"get": operation("Describe all tables", nil, nil, map[string]Any{"tables": map[string]Any{"type": "array"}}),
+		},
+		"/_batch": map[string]Any{
+			"post": operation("Run operations in one transaction", nil, jsonContent(schemaRef("BatchRequest")), map[string]Any{"results": map[string]Any{"type": "array"}}),
+		},
+	}
+
+	schemas := map[string]Any{
+		"Error": map[string]Any{"type": "object", "properties": map[string]Any{"error": map[string]Any{"type": "string"}, "response": anySchema}},
+		"PatchOperation": map[string]Any{
+			"type":     "object",
+			"required": []Any{"op", "path"},
+			"properties": map[string]Any{
+				"op":    map[string]Any{"type": "string", "enum": []Any{"add", "remove", "replace", "move", "copy", "test"}},
+				"path":  map[string]Any{"type": "string"},
+				"from":  map[string]Any{"type": "string"},
+				"value": anySchema,
+			},
+		},
+		"BatchRequest": map[string]Any{
+			"type": "object",
+			"properties": map[string]Any{
+				"operations": map[string]Any{"type": "array", "items": map[string]Any{
+					"type":     "object",
+					"required": []Any{"op", "table"},
+					"properties": map[string]Any{
+						"op":    map[string]Any{"type": "string", "enum": []Any{"create", "update", "delete"}},
+						"table": map[string]Any{"type": "string", "enum": stringsToAny(tables)},
+						"id":    map[string]Any{"description": "record key as in the path, an array or an object of key columns, or a BatchRef"},
+						"body":  map[string]Any{"type": "object", "additionalProperties": map[string]Any{"description": "column value or a BatchRef"}},
+					},
+				}},
+			},
+		},
+	}
+
+	servicePaths(paths)
+	serviceSchemas(schemas)
+
+	for _, t := range tables {
+		explorer.tablePaths(t, paths, schemas)
+	}
+
+	return json.Marshal(map[string]Any{
+		"openapi": "3.1.0",
+		"info":    map[string]Any{"title": "db admin api", "version": "1.0.0"},
+		"paths":   paths,
+		"components": map[string]Any{
+			"schemas":    schemas,
+			"parameters": openAPIParameters,
+		},
+	})
+}
+
+func stringsToAny(in []string) []Any {
+	return mapAny(in, func(s string) Any { return s })
+}
+
+//GET /_openapi.json - описание API в формате OpenAPI 3.1, собирается при старте и при перечитывании схемы
+func (explorer *DbExplorer) handleGetOpenAPI(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(explorer.openapi)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// OrderTerm - элемент ?order=updated.desc.nullslast,id.asc
+type OrderTerm struct {
+	Column string
+	Desc   bool
+	Nulls  string // "", "first" или "last"
+}
+
+func ParseOrder(s string, columns []ColumnInfo) ([]OrderTerm, error) {
+	var terms []OrderTerm
+
+	if len(s) == 0 {
+		return terms, nil
+	}
+
+	for _, item := range strings.Split(s, ",") {
+		parts := strings.Split(item, ".")
+		term := OrderTerm{Column: parts[0]}
+
+		if _, ok := findColumn(columns, term.Column); !ok {
+			return nil, fmt.Errorf("unknown column: %s", term.Column)
+		}
+
+		for _, p := range parts[1:] {
+			switch p {
+			case "asc":
+				term.Desc = false
+			case "desc":
+				term.Desc = true
+			case "nullsfirst":
+				term.Nulls = "first"
+			case "nullslast":
+				term.Nulls = "last"
+			default:
+				return nil, fmt.Errorf("bad order: %s", item)
+			}
+		}
+
+		terms = append(terms, term)
+	}
+
+	return terms, nil
+}
+
+// withTiebreaker дописывает в конец сортировку по pk, чтобы постраничный вывод был стабильным
+func withTiebreaker(terms []OrderTerm, pk string) []OrderTerm {
+	for _, t := range terms {
+		if t.Column == pk {
+			return terms
+		}
+	}
+
+	return append(terms, OrderTerm{Column: pk})
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestOrderBy(t *testing.T) {
+	columns := []ColumnInfo{{Name: "id"}, {Name: "updated"}}
+
+	terms, e := ParseOrder("updated.desc.nullslast", columns)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	b := QueryBuilder{dialect: MySQLDialect{}, schema: map[string][]ColumnInfo{"items": columns}}
+	got, _, e := b.Select(SelectQuery{Table: "items", Order: withTiebreaker(terms, "id")})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	want := "SELECT `id`, `updated` FROM `items` ORDER BY `updated` IS NULL ASC, `updated` DESC, `id` ASC"
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	terms, _ = ParseOrder("id.desc", columns)
+	if got := withTiebreaker(terms, "id"); len(got) != 1 {
+		t.Errorf("pk must not be added twice: %v", got)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+func (explorer *DbExplorer) findPKs(tableName string) []ColumnInfo {
+	var pks []ColumnInfo
+	for _, col := range explorer.columnTypes[tableName] {
+		if col.PrimaryKey {
+			pks = append(pks, col)
+		}
+	}
+
+	return pks
+}
+
+// autoIncrementPk возвращает имя автоинкрементного primary key или пустую строку
+func (explorer *DbExplorer) autoIncrementPk(tableName string) string {
+	for _, pk := range explorer.findPKs(tableName) {
+		if pk.AutoIncrement {
+			return pk.Name
+		}
+	}
+
+	return ""
+}
+
+// pkValues достаёт из вставленных значений ключ записи, его и отдаём клиенту вместо автоинкрементного id
+func (explorer *DbExplorer) pkValues(tableName string, kv map[string]Any) map[string]Any {
+	ids := map[string]Any{}
+	for _, pk := range explorer.findPKs(tableName) {
+		ids[pk.Name] = kv[pk.Name]
+	}
+
+	return ids
+}
+
+// isMatrixId: $id записан матричными параметрами. Так пишется только составной ключ, и первым должно идти имя
+// колонки ключа - иначе "=" считается частью значения (base64 в строковом id, "1,a=b")
+func isMatrixId(raw string, pks []ColumnInfo) bool {
+	if len(pks) < 2 {
+		return false
+	}
+
+	kv := strings.SplitN(strings.TrimPrefix(raw, ";"), "=", 2)
+	if len(kv) != 2 {
+		return false
+	}
+
+	_, ok := findColumn(pks, kv[0])
+
+	return ok
+}
+
+// ParseId разбирает $id из пути в условия по колонкам primary key. Поддерживаются:
+// /items/15, /tags/go-lang, составной ключ /item_tags/1,go и матричные параметры /item_tags/item_id=1;tag=go
+func ParseId(raw string, pks []ColumnInfo) ([]Filter, error) {
+	if len(pks) == 0 {
+		return nil, fmt.Errorf("cannot find pk")
+	}
+
+	values := map[string]string{}
+
+	if isMatrixId(raw, pks) {
+		for _, param := range strings.Split(strings.TrimPrefix(raw, ";"), ";") {
+			kv := strings.SplitN(param, "=", 2)
+
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("bad id: %s", raw)
+			}
+
+			if _, ok := findColumn(pks, kv[0]); !ok {
+				return nil, fmt.Errorf("unknown key column: %s", kv[0])
+			}
+
+			values[kv[0]] = kv[1]
+		}
+	} else if len(pks) == 1 {
+		// одиночный ключ не режем по запятым: это может быть часть строкового id
+		values[pks[0].Name] = raw
+	} else {
+		parts := strings.Split(raw, ",")
+
+		if len(parts) != len(pks) {
+			return nil, fmt.Errorf("expected %d key values", len(pks))
+		}
+
+		for i, pk := range pks {
+			values[pk.Name] = parts[i]
+		}
+	}
+
+	keys := make(map[string]Any, len(values))
+	for k, v := range values {
+		keys[k] = v
+	}
+
+	return keyFilters(keys, pks)
+}
+
+// ParseIdValue - то же, что ParseId, но для id из json (POST /_batch): строка разбирается как $id в пути,
+// массив - значения колонок ключа по порядку, объект - значения по именам колонок
+func ParseIdValue(id Any, pks []ColumnInfo) ([]Filter, error) {
+	if len(pks) == 0 {
+		return nil, fmt.Errorf("cannot find pk")
+	}
+
+	switch t := id.(type) {
+	case nil:
+		return nil, fmt.Errorf("id is missing")
+	case string:
+		return ParseId(t, pks)
+	case []Any:
+		if len(t) != len(pks) {
+			return nil, fmt.Errorf("expected %d key values", len(pks))
+		}
+
+		keys := make(map[string]Any, len(pks))
+		for i, pk := range pks {
+			keys[pk.Name] = t[i]
+		}
+
+		return keyFilters(keys, pks)
+	case map[string]Any:
+		for k := range t {
+			if _, ok := findColumn(pks, k); !ok {
+				return nil, fmt.Errorf("unknown key column: %s", k)
+			}
+		}
+
+		return keyFilters(t, pks)
+	default:
+		if len(pks) != 1 {
+			return nil, fmt.Errorf("expected %d key values", len(pks))
+		}
+
+		return keyFilters(map[string]Any{pks[0].Name: t}, pks)
+	}
+}
+
+// keyFilters собирает условия по всем колонкам ключа. Строки пришли из пути и приводятся к типу колонки как в фильтрах
+func keyFilters(values map[string]Any, pks []ColumnInfo) ([]Filter, error) {
+	filters := make([]Filter, 0, len(pks))
+	for _, pk := range pks {
+		v, ok := values[pk.Name]
+
+		if !ok {
+			return nil, fmt.Errorf("%s is missing", pk.Name)
+		}
+
+		if s, isString := v.(string); isString {
+			v = queryValue(pk.ColumnType, s)
+		}
+
+		parsed, e := ParseByColumnType(pk.Name, pk.ColumnType, v)
+		if e != nil {
+			return nil, e
+		}
+
+		filters = append(filters, pkFilter(pk.Name, parsed))
+	}
+
+	return filters, nil
+}
+
+// idFilters - условия на запись из /$table/$id; ошибка разбора id - ошибка клиента
+func (explorer *DbExplorer) idFilters(rp *RequestParams) ([]Filter, error) {
+	return ParseId(rp.Id, explorer.findPKs(rp.Table))
+}
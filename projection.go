@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseSelect разбирает ?select=id,title и возвращает выбранные колонки в запрошенном порядке.
+// Без параметра возвращаются все колонки таблицы
+func ParseSelect(s string, columns []ColumnInfo) ([]ColumnInfo, error) {
+	if len(s) == 0 {
+		return columns, nil
+	}
+
+	var selected []ColumnInfo
+	seen := map[string]bool{}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		column, ok := findColumn(columns, name)
+
+		if !ok {
+			return nil, fmt.Errorf("unknown column: %s", name)
+		}
+
+		if seen[name] {
+			continue
+		}
+
+		seen[name] = true
+		selected = append(selected, column)
+	}
+
+	return selected, nil
+}
+
+func columnNames(columns []ColumnInfo) []string {
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.Name
+	}
+
+	return names
+}
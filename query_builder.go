@@ -0,0 +1,441 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// QueryBuilder - единственное место, где собирается SQL к данным.
+// Имена таблиц и колонок берутся только из прочитанной схемы и всегда квотируются диалектом,
+// значения всегда уходят в запрос плейсхолдерами
+type QueryBuilder struct {
+	dialect Dialect
+	schema  map[string][]ColumnInfo
+}
+
+// Keyset - условие "строго после значений ключа" для постраничного вывода курсором
+type Keyset struct {
+	Order  []OrderTerm
+	Values []Any
+}
+
+type SelectQuery struct {
+	Table   string
+	Columns []string // пустой список - все колонки таблицы
+	Filters []Filter
+	Keyset  *Keyset
+	Order   []OrderTerm
+	Limit   int // 0 - без LIMIT
+	Offset  int
+	// ForUpdate блокирует выбранные строки до конца транзакции; sqlite блокирует базу целиком и FOR UPDATE не знает
+	ForUpdate bool
+}
+
+// sqlArgs накапливает аргументы и выдаёт для них плейсхолдеры по порядку
+type sqlArgs struct {
+	dialect Dialect
+	values  []Any
+}
+
+func (a *sqlArgs) add(v Any) string {
+	a.values = append(a.values, v)
+
+	return a.dialect.Placeholder(len(a.values))
+}
+
+func (b QueryBuilder) table(name string) (string, error) {
+	if _, ok := b.schema[name]; !ok {
+		return "", fmt.Errorf("unknown table")
+	}
+
+	return b.dialect.QuoteIdent(name), nil
+}
+
+func (b QueryBuilder) column(table, name string) (string, error) {
+	if _, ok := findColumn(b.schema[table], name); !ok {
+		return "", fmt.Errorf("unknown column: %s", name)
+	}
+
+	return b.dialect.QuoteIdent(name), nil
+}
+
+func (b QueryBuilder) columns(table string, names []string) (string, error) {
+	if len(names) == 0 {
+		for _, c := range b.schema[table] {
+			names = append(names, c.Name)
+		}
+	}
+
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		col, e := b.column(table, name)
+
+		if e != nil {
+			return "", e
+		}
+
+		quoted[i] = col
+	}
+
+	return strings.Join(quoted, ", "), nil
+}
+
+func (b QueryBuilder) where(table string, filters []Filter, keyset *Keyset, args *sqlArgs) (string, error) {
+	var conds []string
+
+	for _, f := range filters {
+		col, e := b.column(table, f.Column)
+
+		if e != nil {
+			return "", e
+		}
+
+		switch f.Op {
+		case "is":
+			if f.Values[0] == "null" {
+				conds = append(conds, col+" IS NULL")
+			} else {
+				conds = append(conds, col+" IS NOT NULL")
+			}
+		case "in":
+			qs := make([]string, len(f.Values))
+			for i, v := range f.Values {
+				qs[i] = args.add(v)
+			}
+			conds = append(conds, fmt.Sprintf("%s IN (%s)", col, strings.Join(qs, ", ")))
+		case "ilike":
+			conds = append(conds, fmt.Sprintf("LOWER(%s) LIKE LOWER(%s)", col, args.add(f.Values[0])))
+		default:
+			op, ok := filterOperators[f.Op]
+
+			if !ok {
+				return "", fmt.Errorf("unknown filter operator: %s", f.Op)
+			}
+
+			conds = append(conds, fmt.Sprintf("%s %s %s", col, op, args.add(f.Values[0])))
+		}
+	}
+
+	if keyset != nil {
+		cond, e := b.keyset(table, keyset, args)
+
+		if e != nil {
+			return "", e
+		}
+
+		conds = append(conds, cond)
+	}
+
+	return strings.Join(conds, " AND "), nil
+}
+
+// keyset собирает (a > ?) OR (a = ? AND b > ?) ... в порядке сортировки
+func (b QueryBuilder) keyset(table string, k *Keyset, args *sqlArgs) (string, error) {
+	var ors []string
+
+	for i, t := range k.Order {
+		var ands []string
+		for j := 0; j < i; j++ {
+			col, e := b.column(table, k.Order[j].Column)
+
+			if e != nil {
+				return "", e
+			}
+
+			ands = append(ands, fmt.Sprintf("%s = %s", col, args.add(k.Values[j])))
+		}
+
+		col, e := b.column(table, t.Column)
+
+		if e != nil {
+			return "", e
+		}
+
+		op := ">"
+		if t.Desc {
+			op = "<"
+		}
+		ands = append(ands, fmt.Sprintf("%s %s %s", col, op, args.add(k.Values[i])))
+
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+
+	return "(" + strings.Join(ors, " OR ") + ")", nil
+}
+
+// orderBy: NULLS FIRST/LAST в mysql нет, поэтому порядок null-ов задаётся через "col IS NULL", что понимают все диалекты
+func (b QueryBuilder) orderBy(table string, terms []OrderTerm) (string, error) {
+	var parts []string
+
+	for _, t := range terms {
+		col, e := b.column(table, t.Column)
+
+		if e != nil {
+			return "", e
+		}
+
+		switch t.Nulls {
+		case "first":
+			parts = append(parts, col+" IS NULL DESC")
+		case "last":
+			parts = append(parts, col+" IS NULL ASC")
+		}
+
+		if t.Desc {
+			parts = append(parts, col+" DESC")
+		} else {
+			parts = append(parts, col+" ASC")
+		}
+	}
+
+	return strings.Join(parts, ", "), nil
+}
+
+// Where возвращает условие без слова WHERE, например для оценки числа строк диалектом
+func (b QueryBuilder) Where(table string, filters []Filter) (string, []Any, error) {
+	if _, e := b.table(table); e != nil {
+		return "", nil, e
+	}
+
+	args := &sqlArgs{dialect: b.dialect}
+	where, e := b.where(table, filters, nil, args)
+
+	return where, args.values, e
+}
+
+func (b QueryBuilder) Select(q SelectQuery) (string, []Any, error) {
+	table, e := b.table(q.Table)
+	if e != nil {
+		return "", nil, e
+	}
+
+	columns, e := b.columns(q.Table, q.Columns)
+	if e != nil {
+		return "", nil, e
+	}
+
+	args := &sqlArgs{dialect: b.dialect}
+	query := fmt.Sprintf("SELECT %s FROM %s", columns, table)
+
+	where, e := b.where(q.Table, q.Filters, q.Keyset, args)
+	if e != nil {
+		return "", nil, e
+	}
+	if len(where) > 0 {
+		query += " WHERE " + where
+	}
+
+	order, e := b.orderBy(q.Table, q.Order)
+	if e != nil {
+		return "", nil, e
+	}
+	if len(order) > 0 {
+		query += " ORDER BY " + order
+	}
+
+	if q.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %s OFFSET %s", args.add(q.Limit), args.add(q.Offset))
+	}
+
+	if q.ForUpdate && b.dialect.Name() != "sqlite" {
+		query += " FOR UPDATE"
+	}
+
+	return query, args.values, nil
+}
+
+func (b QueryBuilder) Count(tableName string, filters []Filter) (string, []Any, error) {
+	table, e := b.table(tableName)
+	if e != nil {
+		return "", nil, e
+	}
+
+	args := &sqlArgs{dialect: b.dialect}
+	query := "SELECT COUNT(*) FROM " + table
+
+	where, e := b.where(tableName, filters, nil, args)
+	if e != nil {
+		return "", nil, e
+	}
+	if len(where) > 0 {
+		query += " WHERE " + where
+	}
+
+	return query, args.values, nil
+}
+
+func (b QueryBuilder) Insert(tableName string, columns []string, values []Any) (string, []Any, error) {
+	table, e := b.table(tableName)
+	if e != nil {
+		return "", nil, e
+	}
+
+	if len(columns) != len(values) {
+		return "", nil, fmt.Errorf("columns and values count mismatch")
+	}
+
+	if len(columns) == 0 {
+		// DEFAULT VALUES не понимает mysql, а пустые скобки - postgres и sqlite
+		if b.dialect.Name() == "mysql" {
+			return fmt.Sprintf("INSERT INTO %s () VALUES ()", table), nil, nil
+		}
+
+		return fmt.Sprintf("INSERT INTO %s DEFAULT VALUES", table), nil, nil
+	}
+
+	cols, e := b.columns(tableName, columns)
+	if e != nil {
+		return "", nil, e
+	}
+
+	args := &sqlArgs{dialect: b.dialect}
+	qs := make([]string, len(values))
+	for i, v := range values {
+		qs[i] = args.add(v)
+	}
+
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, cols, strings.Join(qs, ", ")), args.values, nil
+}
+
+// InsertMany собирает один INSERT на несколько строк с одинаковым набором колонок
+func (b QueryBuilder) InsertMany(tableName string, columns []string, rows [][]Any) (string, []Any, error) {
+	table, e := b.table(tableName)
+	if e != nil {
+		return "", nil, e
+	}
+
+	if len(columns) == 0 || len(rows) == 0 {
+		return "", nil, fmt.Errorf("nothing to insert")
+	}
+
+	cols, e := b.columns(tableName, columns)
+	if e != nil {
+		return "", nil, e
+	}
+
+	args := &sqlArgs{dialect: b.dialect}
+	tuples := make([]string, len(rows))
+	for i, values := range rows {
+		if len(values) != len(columns) {
+			return "", nil, fmt.Errorf("columns and values count mismatch")
+		}
+
+		qs := make([]string, len(values))
+		for j, v := range values {
+			qs[j] = args.add(v)
+		}
+		tuples[i] = "(" + strings.Join(qs, ", ") + ")"
+	}
+
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", table, cols, strings.Join(tuples, ", ")), args.values, nil
+}
+
+// Upsert - многострочный INSERT, который при конфликте по уникальному ключу target обновляет существующую запись
+// значениями из вставки. mysql ключ конфликта выбирает сам, target там только проверяется
+func (b QueryBuilder) Upsert(tableName string, columns []string, rows [][]Any, target []string) (string, []Any, error) {
+	insert, args, e := b.InsertMany(tableName, columns, rows)
+	if e != nil {
+		return "", nil, e
+	}
+
+	if len(target) == 0 {
+		return "", nil, fmt.Errorf("empty conflict target")
+	}
+
+	targetCols, e := b.columns(tableName, target)
+	if e != nil {
+		return "", nil, e
+	}
+
+	inTarget := map[string]bool{}
+	for _, name := range target {
+		inTarget[name] = true
+	}
+
+	var update []string
+	for _, name := range columns {
+		if !inTarget[name] {
+			update = append(update, name)
+		}
+	}
+	if len(update) == 0 {
+		// обновлять нечего, но запись должна считаться затронутой
+		update = target
+	}
+
+	sets := make([]string, len(update))
+	for i, name := range update {
+		col, ce := b.column(tableName, name)
+
+		if ce != nil {
+			return "", nil, ce
+		}
+
+		if b.dialect.Name() == "mysql" {
+			sets[i] = fmt.Sprintf("%s = VALUES(%s)", col, col)
+		} else {
+			sets[i] = fmt.Sprintf("%s = EXCLUDED.%s", col, col)
+		}
+	}
+
+	if b.dialect.Name() == "mysql" {
+		return insert + " ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", "), args, nil
+	}
+
+	return fmt.Sprintf("%s ON CONFLICT (%s) DO UPDATE SET %s", insert, targetCols, strings.Join(sets, ", ")), args, nil
+}
+
+func (b QueryBuilder) Update(tableName string, columns []string, values []Any, filters []Filter) (string, []Any, error) {
+	table, e := b.table(tableName)
+	if e != nil {
+		return "", nil, e
+	}
+
+	if len(columns) == 0 || len(columns) != len(values) {
+		return "", nil, fmt.Errorf("nothing to update")
+	}
+
+	args := &sqlArgs{dialect: b.dialect}
+	subs := make([]string, len(columns))
+	for i, name := range columns {
+		col, ce := b.column(tableName, name)
+
+		if ce != nil {
+			return "", nil, ce
+		}
+
+		subs[i] = fmt.Sprintf("%s = %s", col, args.add(values[i]))
+	}
+
+	query := fmt.Sprintf("UPDATE %s SET %s", table, strings.Join(subs, ", "))
+
+	where, e := b.where(tableName, filters, nil, args)
+	if e != nil {
+		return "", nil, e
+	}
+	if len(where) > 0 {
+		query += " WHERE " + where
+	}
+
+	return query, args.values, nil
+}
+
+func (b QueryBuilder) Delete(tableName string, filters []Filter) (string, []Any, error) {
+	table, e := b.table(tableName)
+	if e != nil {
+		return "", nil, e
+	}
+
+	args := &sqlArgs{dialect: b.dialect}
+	query := "DELETE FROM " + table
+
+	where, e := b.where(tableName, filters, nil, args)
+	if e != nil {
+		return "", nil, e
+	}
+	if len(where) > 0 {
+		query += " WHERE " + where
+	}
+
+	return query, args.values, nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+)
+
+// writeRecord меняет одну запись $table/$id в транзакции: блокирует её, проверяет If-Match и передаёт текущее
+// состояние в change, который возвращает значения колонок для update. Ответ - как у POST /$table/$id
+func (explorer *DbExplorer) writeRecord(w http.ResponseWriter, r *http.Request, rp *RequestParams,
+	change func(current map[string]Any) (map[string]Any, error)) {
+	if te := explorer.tableShouldExist(rp.Table); te != nil {
+		handleServerError(w, http.StatusNotFound, te)
+
+		return
+	}
+
+	idFilters, ie := explorer.idFilters(rp)
+	if ie != nil {
+		handleServerError(w, http.StatusBadRequest, ie)
+
+		return
+	}
+
+	tx, be := explorer.db.Begin()
+	panicOnError(be)
+	defer tx.Rollback()
+
+	locked, le := explorer.lockRecord(tx, rp.Table, idFilters)
+	panicOnError(le)
+	if locked == nil {
+		handleServerError(w, http.StatusNotFound, fmt.Errorf("record not found"))
+
+		return
+	}
+
+	if ifMatch := r.Header.Get("If-Match"); len(ifMatch) > 0 && !etagMatches(ifMatch, recordETag(locked), false) {
+		handleServerError(w, http.StatusPreconditionFailed, fmt.Errorf("precondition failed"))
+
+		return
+	}
+
+	// запись переводится в те же типы, что приходят из тела запроса, чтобы патч и сравнение работали с обычным json
+	current, _ := deepCopy(locked).(map[string]Any)
+	kv, ce := change(current)
+	if violations, ok := ce.(Violations); ok {
+		handleViolations(w, violations)
+
+		return
+	}
+	if ce != nil {
+		if _, ok := ce.(ApiError); !ok {
+			ce = ApiError{http.StatusBadRequest, ce}
+		}
+		handleApiError(w, ce)
+
+		return
+	}
+
+	rowsAffected, ue := explorer.updateRows(tx, rp.Table, kv, idFilters)
+	panicOnError(ue)
+	record, re := explorer.readRecord(tx, rp.Table, idFilters)
+	panicOnError(re)
+	panicOnError(tx.Commit())
+
+	w.Header().Set("ETag", recordETag(record))
+
+	response := map[string]interface{}{"updated": rowsAffected}
+	if wantsRepresentation(r) {
+		preferenceApplied(w)
+		response["record"] = record
+	}
+	handleServerResponse(w, response)
+}
+
+// patchValues сравнивает запись после патча с текущей и возвращает только изменившиеся колонки.
+// Удалённый ключ означает NULL, менять primary key нельзя
+func (explorer *DbExplorer) patchValues(tableName string, current map[string]Any, patched Any) (map[string]Any, error) {
+	doc, ok := patched.(map[string]Any)
+	if !ok {
+		return nil, fmt.Errorf("record must be an object")
+	}
+
+	columns := explorer.columnTypes[tableName]
+	for k := range doc {
+		if _, ok := findColumn(columns, k); !ok {
+			return nil, fmt.Errorf("unknown column: %s", k)
+		}
+	}
+
+	kv := make(map[string]Any, len(doc))
+	for _, c := range columns {
+		v := doc[c.Name]
+		if jsonEqual(current[c.Name], v) {
+			continue
+		}
+
+		if c.PrimaryKey {
+			return nil, invalidType(c.Name)
+		}
+
+		val, _, pe := c.ParseJsonValue(map[string]Any{c.Name: v}, true, false)
+		if pe != nil {
+			return nil, pe
+		}
+		kv[c.Name] = val
+	}
+
+	return kv, nil
+}
+
+//PATCH /$table/$id - частичное обновление записи патчем относительно её текущего состояния.
+//Content-Type: application/merge-patch+json (RFC 7396, его же значит application/json) или application/json-patch+json (RFC 6902)
+func (explorer *DbExplorer) handlePatchTableEntity(w http.ResponseWriter, r *http.Request) {
+	rp := &RequestParams{}
+	if pe := rp.ParseRequestURL(r.URL); pe != nil {
+		handleServerError(w, http.StatusBadRequest, pe)
+
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/merge-patch+json" && mediaType != "application/json-patch+json" && mediaType != "application/json" {
+		handleServerError(w, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content type: %s", mediaType))
+
+		return
+	}
+
+	body, re := ioutil.ReadAll(r.Body)
+	panicOnError(re)
+
+	var (
+		patch Any
+		ops   []PatchOperation
+		de    error
+	)
+	if mediaType == "application/json-patch+json" {
+		de = decodeJsonBody(body, &ops)
+	} else {
+		de = decodeJsonBody(body, &patch)
+	}
+	if de != nil {
+		handleServerError(w, http.StatusBadRequest, fmt.Errorf("bad json"))
+
+		return
+	}
+
+	explorer.writeRecord(w, r, rp, func(current map[string]Any) (map[string]Any, error) {
+		var patched Any = deepCopy(current)
+
+		if mediaType == "application/json-patch+json" {
+			var pe error
+			if patched, pe = applyJsonPatch(patched, ops); pe != nil {
+				return nil, pe
+			}
+		} else {
+			patched = mergePatch(patched, patch)
+		}
+
+		// запись после патча проверяется целиком, как при полной замене
+		if doc, ok := patched.(map[string]Any); ok {
+			if violations := explorer.validateRecord(rp.Table, doc, validateReplace, nil); len(violations) > 0 {
+				return nil, Violations(violations)
+			}
+		}
+
+		return explorer.patchValues(rp.Table, current, patched)
+	})
+}
+
+//PUT /$table/$id - полная замена записи: колонки, которых нет в теле, становятся NULL, а если NULL нельзя - ошибка.
+//primary key в теле можно передать, только если он совпадает с $id
+func (explorer *DbExplorer) handleReplaceTableEntity(w http.ResponseWriter, r *http.Request) {
+	rp := &RequestParams{}
+	if pe := rp.ParseRequestURL(r.URL); pe != nil {
+		handleServerError(w, http.StatusBadRequest, pe)
+
+		return
+	}
+
+	body, re := ioutil.ReadAll(r.Body)
+	panicOnError(re)
+	var data map[string]interface{}
+	if de := decodeJsonBody(body, &data); de != nil || data == nil {
+		handleServerError(w, http.StatusBadRequest, fmt.Errorf("bad json"))
+
+		return
+	}
+
+	if violations := explorer.validateRecord(rp.Table, data, validateReplace, nil); len(violations) > 0 {
+		handleViolations(w, violations)
+
+		return
+	}
+
+	explorer.writeRecord(w, r, rp, func(current map[string]Any) (map[string]Any, error) {
+		kv := make(map[string]Any, len(data))
+
+		for _, c := range explorer.columnTypes[rp.Table] {
+			if c.PrimaryKey {
+				if v, has := data[c.Name]; has && !jsonEqual(current[c.Name], v) {
+					return nil, invalidType(c.Name)
+				}
+
+				continue
+			}
+
+			val, _, pe := c.ParseJsonValue(data, false, false)
+			if pe != nil {
+				return nil, pe
+			}
+			kv[c.Name] = val
+		}
+
+		return kv, nil
+	})
+}
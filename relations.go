@@ -0,0 +1,338 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// сколько значений уходит в один IN (...), чтобы не упереться в лимит плейсхолдеров
+const embedBatchSize = 500
+
+// ForeignKey - колонка Column ссылается на RefColumn таблицы RefTable
+type ForeignKey struct {
+	Column    string
+	RefTable  string
+	RefColumn string
+}
+
+// Relation - то, что можно вложить в запись через ?embed=
+type Relation struct {
+	Name      string
+	Table     string // таблица, из которой берутся вложенные записи
+	Column    string // колонка текущей таблицы
+	RefColumn string // колонка в Table
+	Many      bool   // дочерние записи вкладываются массивом, родительская - объектом
+}
+
+// resolveForeignKeys оставляет ключи на известные таблицы и колонки и подставляет pk вместо пустой ссылочной колонки
+func resolveForeignKeys(keys []ForeignKey, schema map[string][]ColumnInfo) []ForeignKey {
+	var resolved []ForeignKey
+
+	for _, fk := range keys {
+		columns, ok := schema[fk.RefTable]
+		if !ok {
+			continue
+		}
+
+		if len(fk.RefColumn) == 0 {
+			var pks []string
+			for _, c := range columns {
+				if c.PrimaryKey {
+					pks = append(pks, c.Name)
+				}
+			}
+
+			if len(pks) != 1 {
+				continue
+			}
+			fk.RefColumn = pks[0]
+		}
+
+		if _, ok := findColumn(columns, fk.RefColumn); ok {
+			resolved = append(resolved, fk)
+		}
+	}
+
+	return resolved
+}
+
+// countRefs - сколько внешних ключей из keys ссылается на refTable
+func countRefs(keys []ForeignKey, refTable string) int {
+	n := 0
+	for _, fk := range keys {
+		if fk.RefTable == refTable {
+			n++
+		}
+	}
+
+	return n
+}
+
+// parentName: author_id -> author. Для колонки без суффикса берётся имя родительской таблицы,
+// а если на неё ссылается несколько колонок (siblings - все внешние ключи таблицы) - колонка и таблица: author_users
+func parentName(fk ForeignKey, siblings []ForeignKey) string {
+	name := strings.TrimSuffix(fk.Column, "_id")
+	if name != fk.Column && len(name) > 0 {
+		return name
+	}
+
+	if countRefs(siblings, fk.RefTable) > 1 {
+		return fk.Column + "_" + fk.RefTable
+	}
+
+	return fk.RefTable
+}
+
+// relations перечисляет связи таблицы: родителей по её внешним ключам и детей, ссылающихся на неё.
+// Дочерние связи называются именем дочерней таблицы, а если дочерняя таблица ссылается на эту несколькими
+// колонками - ещё и по колонке: posts_by_author, posts_by_editor
+func (explorer *DbExplorer) relations(tableName string) []Relation {
+	var rels []Relation
+
+	keys := explorer.foreignKeys[tableName]
+	for _, fk := range keys {
+		rels = append(rels, Relation{Name: parentName(fk, keys), Table: fk.RefTable, Column: fk.Column, RefColumn: fk.RefColumn})
+	}
+
+	var tables []string
+	for t := range explorer.foreignKeys {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+
+	for _, t := range tables {
+		childKeys := explorer.foreignKeys[t]
+		for _, fk := range childKeys {
+			if fk.RefTable == tableName {
+				name := t
+				if countRefs(childKeys, tableName) > 1 {
+					name = t + "_by_" + parentName(fk, childKeys)
+				}
+				rels = append(rels, Relation{Name: name, Table: t, Column: fk.RefColumn, RefColumn: fk.Column, Many: true})
+			}
+		}
+	}
+
+	return rels
+}
+
+// ParseEmbed разбирает ?embed=author,comments. Связь вкладывается в запись под своим именем,
+// поэтому имя, совпадающее с колонкой таблицы, не принимается: вложение затёрло бы значение колонки
+func ParseEmbed(s string, rels []Relation, columns []ColumnInfo) ([]Relation, error) {
+	var selected []Relation
+
+	if len(s) == 0 {
+		return selected, nil
+	}
+
+	seen := map[string]bool{}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+
+		if seen[name] {
+			continue
+		}
+
+		var found []Relation
+		for _, rel := range rels {
+			if rel.Name == name {
+				found = append(found, rel)
+			}
+		}
+
+		switch {
+		case len(found) == 0:
+			return nil, fmt.Errorf("unknown relation: %s", name)
+		case len(found) > 1:
+			return nil, fmt.Errorf("ambiguous relation: %s", name)
+		}
+
+		if _, ok := findColumn(columns, name); ok {
+			return nil, fmt.Errorf("relation %s has the same name as a column", name)
+		}
+		selected = append(selected, found[0])
+
+		seen[name] = true
+	}
+
+	return selected, nil
+}
+
+// embedColumns дописывает к выбранным колонкам те, по которым связываются записи
+func embedColumns(selected, all []ColumnInfo, rels []Relation) []ColumnInfo {
+	for _, rel := range rels {
+		if _, ok := findColumn(selected, rel.Column); !ok {
+			column, _ := findColumn(all, rel.Column)
+			selected = append(selected[:len(selected):len(selected)], column)
+		}
+	}
+
+	return selected
+}
+
+// embed вкладывает связанные записи: по одному запросу с IN (...) на связь, а не по запросу на запись
+func (explorer *DbExplorer) embed(records []interface{}, rels []Relation) error {
+	for _, rel := range rels {
+		refColumn, _ := findColumn(explorer.columnTypes[rel.Table], rel.RefColumn)
+
+		var values []Any
+		seen := map[string]bool{}
+		for _, record := range records {
+			v := record.(map[string]interface{})[rel.Column]
+			key := fmt.Sprint(v)
+
+			if v == nil || seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			parsed, pe := ParseByColumnType(refColumn.Name, refColumn.ColumnType, v)
+			if pe != nil {
+				return pe
+			}
+			values = append(values, parsed)
+		}
+
+		var order []OrderTerm
+		for _, pk := range explorer.findPKs(rel.Table) {
+			order = withTiebreaker(order, pk.Name)
+		}
+
+		related := map[string][]interface{}{}
+		for from := 0; from < len(values); from += embedBatchSize {
+			to := from + embedBatchSize
+			if to > len(values) {
+				to = len(values)
+			}
+
+			query, args, be := explorer.builder.Select(SelectQuery{
+				Table:   rel.Table,
+				Filters: []Filter{{Column: rel.RefColumn, Op: "in", Values: values[from:to]}},
+				Order:   order,
+			})
+			if be != nil {
+				return be
+			}
+
+			rows, qe := explorer.db.Query(query, args...)
+			if qe != nil {
+				return qe
+			}
+			js, je := rowsToJson(explorer.columnTypes[rel.Table], rows)
+			if je != nil {
+				rows.Close()
+
+				return je
+			}
+			if ce := rows.Close(); ce != nil {
+				return ce
+			}
+
+			for _, r := range js {
+				key := fmt.Sprint(r.(map[string]interface{})[rel.RefColumn])
+				related[key] = append(related[key], r)
+			}
+		}
+
+		for _, record := range records {
+			m := record.(map[string]interface{})
+			found := related[fmt.Sprint(m[rel.Column])]
+
+			if m[rel.Column] == nil {
+				found = nil
+			}
+
+			if rel.Many {
+				if found == nil {
+					found = []interface{}{}
+				}
+				m[rel.Name] = found
+			} else if len(found) > 0 {
+				m[rel.Name] = found[0]
+			} else {
+				m[rel.Name] = nil
+			}
+		}
+	}
+
+	return nil
+}
+
+// parentFilter для /$parent/$id/$table возвращает условие "внешний ключ указывает на родителя".
+// Родитель должен существовать, иначе 404; для обычных маршрутов возвращает nil
+func (explorer *DbExplorer) parentFilter(rp *RequestParams) (*Filter, int, error) {
+	if len(rp.Parent) == 0 {
+		return nil, 0, nil
+	}
+
+	if te := explorer.tableShouldExist(rp.Parent); te != nil {
+		return nil, http.StatusNotFound, te
+	}
+
+	var rels []Relation
+	for _, r := range explorer.relations(rp.Parent) {
+		if r.Many && r.Table == rp.Table {
+			rels = append(rels, r)
+		}
+	}
+
+	if len(rels) == 0 {
+		return nil, http.StatusNotFound, fmt.Errorf("unknown relation: %s", rp.Table)
+	}
+
+	// по пути не понять, через какую из колонок связаны таблицы: выбирать надо фильтром /$table?column=eq.$id
+	if len(rels) > 1 {
+		columns := make([]string, len(rels))
+		for i, r := range rels {
+			columns[i] = r.RefColumn
+		}
+		sort.Strings(columns)
+
+		return nil, http.StatusBadRequest, fmt.Errorf("ambiguous relation: %s references %s by %s", rp.Table, rp.Parent, strings.Join(columns, ", "))
+	}
+	rel := rels[0]
+
+	refColumn, _ := findColumn(explorer.columnTypes[rp.Parent], rel.Column)
+	filters, ie := ParseId(rp.ParentId, []ColumnInfo{refColumn})
+	if ie != nil {
+		return nil, http.StatusBadRequest, ie
+	}
+
+	query, args, be := explorer.builder.Select(SelectQuery{
+		Table:   rp.Parent,
+		Columns: []string{rel.Column},
+		Filters: filters,
+		Limit:   1,
+	})
+	if be != nil {
+		return nil, http.StatusInternalServerError, be
+	}
+
+	rows, qe := explorer.db.Query(query, args...)
+	if qe != nil {
+		return nil, http.StatusInternalServerError, qe
+	}
+	found := rows.Next()
+	if ce := rows.Close(); ce != nil {
+		return nil, http.StatusInternalServerError, ce
+	}
+
+	if !found {
+		return nil, http.StatusNotFound, fmt.Errorf("record not found")
+	}
+
+	f := pkFilter(rel.RefColumn, filters[0].Values[0])
+
+	return &f, 0, nil
+}
+
+// stripColumns убирает из записей колонки, которые читались только для служебных нужд
+func stripColumns(records []interface{}, extra []ColumnInfo) {
+	for _, record := range records {
+		for _, c := range extra {
+			delete(record.(map[string]interface{}), c.Name)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+)
+
+// wantsRepresentation: Prefer: return=representation - вернуть записанную запись целиком,
+// со значениями по умолчанию и результатами триггеров, чтобы клиенту не нужен был повторный GET
+func wantsRepresentation(r *http.Request) bool {
+	return parsePrefer(r)["return"] == "representation"
+}
+
+func preferenceApplied(w http.ResponseWriter) {
+	w.Header().Set("Preference-Applied", "return=representation")
+}
+
+// keyFilters превращает ключ записи из ответа на вставку в условия по primary key
+func (explorer *DbExplorer) keyFilters(tableName string, key map[string]Any) []Filter {
+	var filters []Filter
+	for _, pk := range explorer.findPKs(tableName) {
+		filters = append(filters, pkFilter(pk.Name, key[pk.Name]))
+	}
+
+	return filters
+}
+
+// readRecord перечитывает запись в той же транзакции, в которой она была записана. nil - записи нет
+func (explorer *DbExplorer) readRecord(q queryer, tableName string, filters []Filter) (Any, error) {
+	return explorer.selectRecord(q, tableName, filters, false)
+}
+
+// lockRecord читает запись с блокировкой до конца транзакции, чтобы её не изменили между проверкой и записью
+func (explorer *DbExplorer) lockRecord(q queryer, tableName string, filters []Filter) (Any, error) {
+	return explorer.selectRecord(q, tableName, filters, true)
+}
+
+func (explorer *DbExplorer) selectRecord(q queryer, tableName string, filters []Filter, forUpdate bool) (Any, error) {
+	// без ключа запись не найти, а без условий запрос вернул бы всю таблицу
+	if len(filters) == 0 {
+		return nil, nil
+	}
+
+	query, args, be := explorer.builder.Select(SelectQuery{Table: tableName, Filters: filters, ForUpdate: forUpdate})
+	if be != nil {
+		return nil, be
+	}
+
+	rows, qe := q.Query(query, args...)
+	if qe != nil {
+		return nil, qe
+	}
+	js, je := rowsToJson(explorer.columnTypes[tableName], rows)
+	if je != nil {
+		rows.Close()
+
+		return nil, je
+	}
+	if ce := rows.Close(); ce != nil {
+		return nil, ce
+	}
+
+	if len(js) == 0 {
+		return nil, nil
+	}
+
+	return js[0], nil
+}
+
+// withRecord дополняет ответ на вставку ключом "record" с перечитанной записью
+func (explorer *DbExplorer) withRecord(q queryer, tableName string, key map[string]Any) (map[string]Any, error) {
+	record, re := explorer.readRecord(q, tableName, explorer.keyFilters(tableName, key))
+	if re != nil {
+		return nil, re
+	}
+
+	response := make(map[string]Any, len(key)+1)
+	for k, v := range key {
+		response[k] = v
+	}
+	response["record"] = record
+
+	return response, nil
+}
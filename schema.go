@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Index - индекс таблицы, колонки в порядке индекса
+type Index struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+	Unique  bool     `json:"unique"`
+}
+
+type ColumnSchema struct {
+	Name          string  `json:"name"`
+	Type          string  `json:"type"`
+	Nullable      bool    `json:"nullable"`
+	Default       *string `json:"default"`
+	PrimaryKey    bool    `json:"primary_key"`
+	AutoIncrement bool    `json:"auto_increment"`
+	Comment       string  `json:"comment"`
+}
+
+type ForeignKeySchema struct {
+	Column    string `json:"column"`
+	RefTable  string `json:"ref_table"`
+	RefColumn string `json:"ref_column"`
+}
+
+// TableSchema - описание таблицы для построения форм на клиенте. Unique - все уникальные наборы колонок, включая primary key
+type TableSchema struct {
+	Name        string             `json:"name"`
+	Columns     []ColumnSchema     `json:"columns"`
+	PrimaryKey  []string           `json:"primary_key"`
+	Unique      [][]string         `json:"unique"`
+	Indexes     []Index            `json:"indexes"`
+	ForeignKeys []ForeignKeySchema `json:"foreign_keys"`
+}
+
+func (explorer *DbExplorer) tableSchema(tableName string) TableSchema {
+	schema := TableSchema{
+		Name:        tableName,
+		Columns:     []ColumnSchema{},
+		PrimaryKey:  []string{},
+		Unique:      [][]string{},
+		Indexes:     []Index{},
+		ForeignKeys: []ForeignKeySchema{},
+	}
+
+	for _, c := range explorer.columnTypes[tableName] {
+		schema.Columns = append(schema.Columns, ColumnSchema{
+			Name:          c.Name,
+			Type:          c.Type,
+			Nullable:      c.Nullable,
+			Default:       c.Default,
+			PrimaryKey:    c.PrimaryKey,
+			AutoIncrement: c.AutoIncrement,
+			Comment:       c.Comment,
+		})
+
+		if c.PrimaryKey {
+			schema.PrimaryKey = append(schema.PrimaryKey, c.Name)
+		}
+	}
+
+	schema.Unique = append(schema.Unique, explorer.uniqueKeys[tableName]...)
+	schema.Indexes = append(schema.Indexes, explorer.indexes[tableName]...)
+
+	for _, fk := range explorer.foreignKeys[tableName] {
+		schema.ForeignKeys = append(schema.ForeignKeys, ForeignKeySchema{
+			Column:    fk.Column,
+			RefTable:  fk.RefTable,
+			RefColumn: fk.RefColumn,
+		})
+	}
+
+	return schema
+}
+
+func isTableSchemaPath(path string) bool {
+	return strings.HasSuffix(path, "/_schema") && strings.Count(path, "/") == 2
+}
+
+func isTableJsonSchemaPath(path string) bool {
+	return strings.HasSuffix(path, "/_schema.json") && strings.Count(path, "/") == 2
+}
+
+//GET /_schema - описание всех таблиц: колонки, индексы, уникальные ключи и внешние ключи
+func (explorer *DbExplorer) handleGetSchema(w http.ResponseWriter, _ *http.Request) {
+	tables := make([]string, 0, len(explorer.columnTypes))
+	for t := range explorer.columnTypes {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+
+	schemas := make([]TableSchema, len(tables))
+	for i, t := range tables {
+		schemas[i] = explorer.tableSchema(t)
+	}
+
+	handleServerResponse(w, map[string]interface{}{"tables": schemas})
+}
+
+//GET /$table/_schema - описание одной таблицы
+func (explorer *DbExplorer) handleGetTableSchema(w http.ResponseWriter, r *http.Request) {
+	tableName := strings.TrimPrefix(strings.TrimSuffix(r.URL.Path, "/_schema"), "/")
+
+	if te := explorer.tableShouldExist(tableName); te != nil {
+		handleServerError(w, http.StatusNotFound, te)
+
+		return
+	}
+
+	handleServerResponse(w, explorer.tableSchema(tableName))
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SchemaCache держит explorer, построенный по текущей схеме базы. Перечитывание собирает новый explorer целиком
+// и подменяет указатель атомарно: запрос, который уже начался, до конца работает со схемой, с которой начал
+type SchemaCache struct {
+	db      *sql.DB
+	dialect Dialect
+	current atomic.Pointer[DbExplorer]
+
+	// перечитывания идут по одному, checksum - контрольная сумма схемы, по которой собран current
+	mu       sync.Mutex
+	checksum string
+}
+
+func NewSchemaCache(db *sql.DB, dialect Dialect) (*SchemaCache, error) {
+	cache := &SchemaCache{db: db, dialect: dialect}
+
+	if e := cache.Reload(); e != nil {
+		return nil, e
+	}
+
+	return cache, nil
+}
+
+func (cache *SchemaCache) Explorer() *DbExplorer {
+	return cache.current.Load()
+}
+
+func (cache *SchemaCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cache.Explorer().ServeHTTP(w, r)
+}
+
+// Reload перечитывает схему. При ошибке остаётся прежняя
+func (cache *SchemaCache) Reload() error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	// сумма считается до чтения схемы: если схема поменяется посередине, следующий опрос увидит разницу
+	checksum, ce := cache.dialect.SchemaChecksum(cache.db)
+	if ce != nil {
+		return ce
+	}
+
+	explorer, le := loadExplorer(cache.db, cache.dialect)
+	if le != nil {
+		return le
+	}
+	explorer.cache = cache
+
+	cache.checksum = checksum
+	cache.current.Store(explorer)
+
+	return nil
+}
+
+// reloadIfChanged перечитывает схему, только если её контрольная сумма изменилась
+func (cache *SchemaCache) reloadIfChanged() (bool, error) {
+	checksum, ce := cache.dialect.SchemaChecksum(cache.db)
+	if ce != nil {
+		return false, ce
+	}
+
+	cache.mu.Lock()
+	same := checksum == cache.checksum
+	cache.mu.Unlock()
+
+	if same {
+		return false, nil
+	}
+
+	return true, cache.Reload()
+}
+
+// ReloadOnSignal перечитывает схему на каждый из сигналов, обычно SIGHUP. Блокируется, запускать в горутине
+func (cache *SchemaCache) ReloadOnSignal(signals ...os.Signal) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, signals...)
+
+	for s := range ch {
+		if e := cache.Reload(); e != nil {
+			fmt.Printf("schema reload on %v: %v\n", s, e)
+		} else {
+			fmt.Printf("schema reloaded on %v\n", s)
+		}
+	}
+}
+
+// Poll раз в interval сверяет контрольную сумму схемы и перечитывает схему, если та изменилась.
+// Закрытый stop останавливает опрос, nil - опрос до конца работы процесса
+func (cache *SchemaCache) Poll(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if changed, e := cache.reloadIfChanged(); e != nil {
+				fmt.Printf("schema poll: %v\n", e)
+			} else if changed {
+				fmt.Println("schema changed, reloaded")
+			}
+		}
+	}
+}
+
+//POST /_admin/reload-schema - перечитать схему базы без перезапуска
+func (explorer *DbExplorer) handleReloadSchema(w http.ResponseWriter, _ *http.Request) {
+	if e := explorer.cache.Reload(); e != nil {
+		handleServerError(w, http.StatusInternalServerError, e)
+
+		return
+	}
+
+	handleServerResponse(w, map[string]interface{}{
+		"reloaded": true,
+		"tables":   len(explorer.cache.Explorer().columnTypes),
+	})
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// parsePrefer разбирает заголовок вида Prefer: return=representation, resolution=merge-duplicates
+func parsePrefer(r *http.Request) map[string]string {
+	prefs := map[string]string{}
+
+	for _, header := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(header, ",") {
+			kv := strings.SplitN(strings.TrimSpace(pref), "=", 2)
+
+			if len(kv) == 2 {
+				prefs[kv[0]] = kv[1]
+			} else if len(kv[0]) > 0 {
+				prefs[kv[0]] = ""
+			}
+		}
+	}
+
+	return prefs
+}
+
+// withPrimaryKey добавляет primary key к уникальным индексам, если диалект его среди индексов не вернул
+func withPrimaryKey(keys [][]string, columns []ColumnInfo) [][]string {
+	var pk []string
+	for _, c := range columns {
+		if c.PrimaryKey {
+			pk = append(pk, c.Name)
+		}
+	}
+
+	if len(pk) == 0 {
+		return keys
+	}
+
+	for _, key := range keys {
+		if sameSet(key, pk) {
+			return keys
+		}
+	}
+
+	return append([][]string{pk}, keys...)
+}
+
+func sameSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	in := map[string]bool{}
+	for _, s := range a {
+		in[s] = true
+	}
+
+	for _, s := range b {
+		if !in[s] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// conflictTarget возвращает колонки ключа конфликта для upsert или nil, если upsert не запрошен.
+// ?on_conflict=login задаёт ключ явно, Prefer: resolution=merge-duplicates без него - это upsert по primary key
+func (explorer *DbExplorer) conflictTarget(r *http.Request, tableName string) ([]string, error) {
+	onConflict := r.URL.Query().Get("on_conflict")
+	merge := parsePrefer(r)["resolution"] == "merge-duplicates"
+
+	if len(onConflict) == 0 && !merge {
+		return nil, nil
+	}
+
+	var target []string
+	if len(onConflict) > 0 {
+		for _, name := range strings.Split(onConflict, ",") {
+			target = append(target, strings.TrimSpace(name))
+		}
+	} else {
+		target = columnNames(explorer.findPKs(tableName))
+	}
+
+	if len(target) == 0 {
+		return nil, fmt.Errorf("upsert requires a primary key or on_conflict")
+	}
+
+	for _, key := range explorer.uniqueKeys[tableName] {
+		if sameSet(key, target) {
+			return target, nil
+		}
+	}
+
+	return nil, fmt.Errorf("on_conflict must match a unique key: %s", strings.Join(target, ","))
+}
+
+// upsertValues - значения для insert, к которым добавлены колонки ключа конфликта:
+// при upsert по автоинкрементному pk его значение берётся из тела
+func (explorer *DbExplorer) upsertValues(tableName string, data map[string]Any, parent *Filter, target []string) (map[string]Any, error) {
+	kv, e := explorer.insertValues(tableName, data, parent)
+	if e != nil {
+		return nil, e
+	}
+
+	for _, name := range target {
+		if _, ok := kv[name]; ok {
+			continue
+		}
+
+		val, has := data[name]
+		if !has || val == nil {
+			return nil, fmt.Errorf("%s is missing", name)
+		}
+
+		column, _ := findColumn(explorer.columnTypes[tableName], name)
+		v, pe := ParseByColumnType(name, column.ColumnType, val)
+		if pe != nil {
+			return nil, pe
+		}
+
+		kv[name] = v
+	}
+
+	return kv, nil
+}
+
+// conflictKey - строка, одинаковая у записей с одинаковыми значениями колонок ключа конфликта
+func conflictKey(kv map[string]Any, target []string) string {
+	return fmt.Sprintf("%#v", mapAny(target, func(k string) Any { return kv[k] }))
+}
+
+// dedupByKey оставляет по одной записи на ключ конфликта: в postgres один upsert не может обновить строку дважды
+// ("cannot affect row a second time"). Побеждает последняя запись, как при вставке по одной; positions[i] -
+// номер в unique записи, которая досталась rows[i]
+func dedupByKey(rows []map[string]Any, target []string) ([]map[string]Any, []int) {
+	var unique []map[string]Any
+	positions := make([]int, len(rows))
+	index := map[string]int{}
+
+	for i, kv := range rows {
+		key := conflictKey(kv, target)
+
+		if p, ok := index[key]; ok {
+			unique[p] = kv
+			positions[i] = p
+
+			continue
+		}
+
+		index[key] = len(unique)
+		positions[i] = len(unique)
+		unique = append(unique, kv)
+	}
+
+	return unique, positions
+}
+
+// keyColumns - колонки, которые возвращаются ключом записи после upsert: primary key, а без него - ключ конфликта
+func (explorer *DbExplorer) keyColumns(tableName string, target []string) []ColumnInfo {
+	columns := explorer.findPKs(tableName)
+	if len(columns) == 0 {
+		for _, name := range target {
+			column, _ := findColumn(explorer.columnTypes[tableName], name)
+			columns = append(columns, column)
+		}
+	}
+
+	return columns
+}
+
+// lookupKeys находит ключи записей по значениям колонок конфликта одним запросом: после upsert LastInsertId ненадёжен,
+// если запись была обновлена, а не вставлена. У составного ключа IN по каждой колонке может вернуть лишние строки,
+// нужные отбираются по всем колонкам сразу
+func (explorer *DbExplorer) lookupKeys(q queryer, tableName string, rows []map[string]Any, target []string) ([]map[string]Any, error) {
+	keyColumns := explorer.keyColumns(tableName, target)
+
+	columns := append([]ColumnInfo{}, keyColumns...)
+	for _, name := range target {
+		if _, ok := findColumn(columns, name); !ok {
+			column, _ := findColumn(explorer.columnTypes[tableName], name)
+			columns = append(columns, column)
+		}
+	}
+
+	filters := make([]Filter, len(target))
+	for i, name := range target {
+		seen := map[string]bool{}
+		filters[i] = Filter{Column: name, Op: "in"}
+
+		for _, kv := range rows {
+			if key := fmt.Sprintf("%#v", kv[name]); !seen[key] {
+				seen[key] = true
+				filters[i].Values = append(filters[i].Values, kv[name])
+			}
+		}
+	}
+
+	query, args, be := explorer.builder.Select(SelectQuery{
+		Table:   tableName,
+		Columns: columnNames(columns),
+		Filters: filters,
+	})
+	if be != nil {
+		return nil, be
+	}
+
+	found, fe := queryRecords(q, columns, query, args)
+	if fe != nil {
+		return nil, fe
+	}
+
+	byKey := map[string]map[string]Any{}
+	for _, record := range found {
+		m := record.(map[string]interface{})
+
+		// значения из базы приводятся к тому же виду, что и значения из тела запроса
+		parsed, pe := explorer.parseRendered(tableName, m, target)
+		if pe != nil {
+			return nil, pe
+		}
+
+		key := map[string]Any{}
+		for _, c := range keyColumns {
+			key[c.Name] = m[c.Name]
+		}
+		byKey[conflictKey(parsed, target)] = key
+	}
+
+	keys := make([]map[string]Any, len(rows))
+	for i, kv := range rows {
+		key, ok := byKey[conflictKey(kv, target)]
+		if !ok {
+			// значение в базе может отличаться от переданного, но совпадать по сравнению базы
+			// (регистронезависимая сортировка в mysql) - такую запись ищем отдельно
+			var le error
+			if key, le = explorer.lookupKey(q, tableName, kv, target); le != nil {
+				return nil, le
+			}
+		}
+		keys[i] = key
+	}
+
+	return keys, nil
+}
+
+// parseRendered разбирает значения колонок записи, прочитанной из базы, как значения из тела запроса
+func (explorer *DbExplorer) parseRendered(tableName string, record map[string]interface{}, names []string) (map[string]Any, error) {
+	body, me := json.Marshal(mapAny(names, func(k string) Any { return record[k] }))
+	if me != nil {
+		return nil, me
+	}
+
+	var values []Any
+	if de := decodeJsonBody(body, &values); de != nil {
+		return nil, de
+	}
+
+	parsed := make(map[string]Any, len(names))
+	for i, name := range names {
+		column, _ := findColumn(explorer.columnTypes[tableName], name)
+
+		v, pe := ParseByColumnType(name, column.ColumnType, values[i])
+		if pe != nil {
+			return nil, pe
+		}
+		parsed[name] = v
+	}
+
+	return parsed, nil
+}
+
+// lookupKey находит ключ одной записи по значениям колонок конфликта
+func (explorer *DbExplorer) lookupKey(q queryer, tableName string, kv map[string]Any, target []string) (map[string]Any, error) {
+	columns := explorer.keyColumns(tableName, target)
+
+	filters := make([]Filter, len(target))
+	for i, name := range target {
+		filters[i] = pkFilter(name, kv[name])
+	}
+
+	query, args, be := explorer.builder.Select(SelectQuery{
+		Table:   tableName,
+		Columns: columnNames(columns),
+		Filters: filters,
+	})
+	if be != nil {
+		return nil, be
+	}
+
+	js, je := queryRecords(q, columns, query, args)
+	if je != nil {
+		return nil, je
+	}
+
+	if len(js) == 0 {
+		return nil, fmt.Errorf("record not found")
+	}
+
+	return js[0].(map[string]interface{}), nil
+}
+
+// queryRecords выполняет запрос и читает все строки
+func queryRecords(q queryer, columns []ColumnInfo, query string, args []Any) ([]interface{}, error) {
+	rows, qe := q.Query(query, args...)
+	if qe != nil {
+		return nil, qe
+	}
+
+	js, je := rowsToJson(columns, rows)
+	if je != nil {
+		rows.Close()
+
+		return nil, je
+	}
+
+	return js, rows.Close()
+}